@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var auditOutput string
+
+var auditCmd = &cobra.Command{
+	Use:     "audit",
+	Short:   "Check that backups hold an unbroken history of changes, for one table or all of them",
+	PreRunE: checkRequiredAuditFlags,
+	RunE:    auditBackups,
+}
+
+func init() {
+	auditCmd.Flags().StringVarP(&auditOutput, "output", "o", "table", "Output format. One of: table, json")
+}
+
+func checkRequiredAuditFlags(cmd *cobra.Command, args []string) error {
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	if auditOutput != "table" && auditOutput != "json" {
+		return errors.New("Error: Unknown output format " + auditOutput)
+	}
+	return nil
+}
+
+func auditBackups(cmd *cobra.Command, args []string) error {
+	w, err := timeWindow()
+	if err != nil {
+		return err
+	}
+	a := newAws()
+	tables := []string{sourceTable}
+	if sourceTable == "" {
+		if tables, err = a.ListTables(); err != nil {
+			return err
+		}
+	}
+	var reports []*restore.AuditReport
+	var unrestorable []string
+	for _, table := range tables {
+		report, err := a.Audit(table, w)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, report)
+		if !report.CanRestore() {
+			unrestorable = append(unrestorable, table)
+		}
+	}
+	if auditOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, report := range reports {
+			fmt.Printf("%s: %d objects, %d records, %d issues\n", report.Table, report.Objects, report.Records, len(report.Issues))
+			for _, issue := range report.Issues {
+				location := issue.Object
+				if issue.Line > 0 {
+					location = fmt.Sprintf("%s:%d", issue.Object, issue.Line)
+				}
+				severity := "warning"
+				if issue.Blocking {
+					severity = "error"
+				}
+				fmt.Printf("  %s %s %s %s\n", severity, issue.Kind, location, issue.Detail)
+			}
+		}
+	}
+	if len(unrestorable) > 0 {
+		return fmt.Errorf("Error: Backups of %v can't support a faithful restore", unrestorable)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupFollow        bool
+	backupPollInterval  time.Duration
+	backupFlushInterval time.Duration
+)
+
+var backupCmd = &cobra.Command{
+	Use:     "backup",
+	Short:   "Back up a table's DynamoDB stream into the bucket in the layout restore reads",
+	PreRunE: checkRequiredBackupFlags,
+	RunE:    backupStream,
+}
+
+func init() {
+	backupCmd.Flags().BoolVarP(&backupFollow, "follow", "f", false, "Keep polling the stream for new records until interrupted")
+	backupCmd.Flags().DurationVarP(&backupPollInterval, "poll-interval", "", 10*time.Second, "How long to wait between polls once caught up with --follow")
+	backupCmd.Flags().DurationVarP(&backupFlushInterval, "flush-interval", "", time.Minute, "How long --follow holds a shard's new records for more before writing them")
+}
+
+func checkRequiredBackupFlags(cmd *cobra.Command, args []string) error {
+	if sourceTable == "" {
+		return flagError("sourceTable")
+	}
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	return nil
+}
+
+func backupStream(cmd *cobra.Command, args []string) error {
+	a := newAws()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stats, err := a.Backup(ctx, sourceTable, restore.BackupOptions{Follow: backupFollow, PollInterval: backupPollInterval, FlushInterval: backupFlushInterval})
+	if stats != nil {
+		fmt.Printf("Backed up %d records into %d objects\n", stats.Records, stats.Objects)
+	}
+	// Interrupting a follow is how it's meant to stop
+	if backupFollow && err == context.Canceled {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var compactCutOff string
+
+var compactCmd = &cobra.Command{
+	Use:     "compact",
+	Short:   "Roll a table's stream backups up to a cut-off into a snapshot restores can start from",
+	PreRunE: checkRequiredCompactFlags,
+	RunE:    compactBackups,
+}
+
+func init() {
+	compactCmd.Flags().StringVarP(&compactCutOff, "cutoff", "", "", "Latest change to include. Format: YYYY-MM-DD-HH:MM. Defaults to the start of the previous hour, whose backups are complete")
+	compactCmd.Flags().IntVarP(&concurrency, "concurrency", "", 4, "Number of backup objects to read at once")
+}
+
+func checkRequiredCompactFlags(cmd *cobra.Command, args []string) error {
+	if sourceTable == "" {
+		return flagError("sourceTable")
+	}
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	return nil
+}
+
+func compactBackups(cmd *cobra.Command, args []string) error {
+	// Records can land in the hour after they're made, so the last hour isn't complete yet
+	cutOff := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+	if compactCutOff != "" {
+		var err error
+		if cutOff, err = parseTimeFlag("cutoff", compactCutOff); err != nil {
+			return err
+		}
+	}
+	a := newAws()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	manifest, err := a.Compact(ctx, sourceTable, cutOff, restore.WithConcurrency(concurrency), restore.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Compacted %d objects into %d items up to %s\n", manifest.Objects, manifest.Items, manifest.CutOff.Format(time.RFC3339))
+	fmt.Println("Snapshot manifest:", manifest.Key)
+	return nil
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/pflag"
+)
+
+// credentialFlags pick the identity one service is called as
+type credentialFlags struct {
+	profile    string
+	roleArn    string
+	externalID string
+}
+
+var (
+	s3Creds         credentialFlags
+	dynamoCreds     credentialFlags
+	roleSessionName string
+)
+
+func (c *credentialFlags) addFlags(flags *pflag.FlagSet, prefix, service string) {
+	flags.StringVarP(&c.profile, prefix+"-profile", "", "", "Shared config profile to call "+service+" with. Defaults to the environment's credentials")
+	flags.StringVarP(&c.roleArn, prefix+"-role-arn", "", "", "Role to assume for "+service+", e.g. one in another account")
+	flags.StringVarP(&c.externalID, prefix+"-external-id", "", "", "External ID to pass when assuming --"+prefix+"-role-arn")
+}
+
+// credentials turns the flags into restore credentials, or nil if none were given
+func (c *credentialFlags) credentials() *restore.Credentials {
+	if c.profile == "" && c.roleArn == "" {
+		return nil
+	}
+	return &restore.Credentials{
+		Profile:     c.profile,
+		RoleArn:     c.roleArn,
+		ExternalID:  c.externalID,
+		SessionName: roleSessionName,
+	}
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/pflag"
+)
+
+var (
+	sseCustomerKeyFile string
+	decryptKeyFile     string
+	decryptKMS         bool
+)
+
+func addDecryptionFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&sseCustomerKeyFile, "sse-c-key-file", "", "", "File holding the 256 bit key backups were stored with using SSE-C, raw or base64 encoded")
+	flags.StringVarP(&decryptKeyFile, "decrypt-key-file", "", "", "File holding the AES master key backups were encrypted client side with, raw or base64 encoded")
+	flags.BoolVarP(&decryptKMS, "decrypt-kms", "", false, "Decrypt backups encrypted client side with KMS envelope encryption, using the S3 credentials")
+}
+
+// decryption sets up reading encrypted backups from the flags, or returns nil if none were given
+func decryption(a *restore.AWS) (*restore.Decryption, error) {
+	if sseCustomerKeyFile == "" && decryptKeyFile == "" && !decryptKMS {
+		return nil, nil
+	}
+	if decryptKeyFile != "" && decryptKMS {
+		return nil, errors.New("Error: Pass either decrypt-key-file or decrypt-kms, not both")
+	}
+	dec := &restore.Decryption{}
+	if sseCustomerKeyFile != "" {
+		key, err := readKeyFile(sseCustomerKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 32 {
+			return nil, errors.New("Error: SSE-C keys must be 256 bits")
+		}
+		dec.SSECustomerKey = key
+	}
+	if decryptKeyFile != "" {
+		key, err := readKeyFile(decryptKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		dec.Keys = &restore.LocalKey{Key: key}
+	}
+	if decryptKMS {
+		keys, err := a.NewKMSKeys()
+		if err != nil {
+			return nil, err
+		}
+		dec.Keys = keys
+	}
+	return dec, nil
+}
+
+// readKeyFile reads an AES key stored either as raw bytes or base64 text
+func readKeyFile(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := raw
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw))); err == nil {
+		key = decoded
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, errors.New("Error: Key in " + path + " must be 128, 192 or 256 bits")
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyKey    string
+	historyOutput string
+	historySort   string
+)
+
+var historyCmd = &cobra.Command{
+	Use:     "history",
+	Short:   "Show every change made to a single item",
+	PreRunE: checkRequiredHistoryFlags,
+	RunE:    showHistory,
+}
+
+func init() {
+	historyCmd.Flags().StringVarP(&historyKey, "key", "", "", "Partition key of the item. Format: name=value")
+	historyCmd.Flags().StringVarP(&historySort, "sort", "", "", "Sort key of the item, if the table has one. Format: name=value")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", "table", "Output format. One of: table, json")
+}
+
+func checkRequiredHistoryFlags(cmd *cobra.Command, args []string) error {
+	if sourceTable == "" {
+		return flagError("sourceTable")
+	}
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	if historyKey == "" {
+		return flagError("key")
+	}
+	if historyOutput != "table" && historyOutput != "json" {
+		return errors.New("Error: Unknown output format " + historyOutput)
+	}
+	return nil
+}
+
+func showHistory(cmd *cobra.Command, args []string) error {
+	w, err := timeWindow()
+	if err != nil {
+		return err
+	}
+	key, err := parseKeyFlag("key", historyKey)
+	if err != nil {
+		return err
+	}
+	conds := []restore.KeyCondition{key}
+	if historySort != "" {
+		sort, err := parseKeyFlag("sort", historySort)
+		if err != nil {
+			return err
+		}
+		conds = append(conds, sort)
+	}
+	a := newAws()
+	events, err := a.History(sourceTable, w, conds)
+	if err != nil {
+		return err
+	}
+	if historyOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(events)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSEQUENCE\tEVENT\tATTRIBUTE\tOLD\tNEW")
+	for _, event := range events {
+		prefix := event.Time.Format(timeFlagLayout) + "\t" + event.SequenceNumber + "\t" + event.EventName
+		if len(event.Changes) == 0 {
+			fmt.Fprintln(tw, prefix+"\t-\t-\t-")
+		}
+		for _, change := range event.Changes {
+			fmt.Fprintln(tw, prefix+"\t"+change.Name+"\t"+restore.FormatAttributeValue(change.Old)+"\t"+restore.FormatAttributeValue(change.New))
+			// Only label the first row of each event
+			prefix = "\t\t"
+		}
+	}
+	return tw.Flush()
+}
+
+func parseKeyFlag(flag, value string) (restore.KeyCondition, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return restore.KeyCondition{}, errors.New("Error: Flag " + flag + " must be formatted as name=value")
+	}
+	return restore.KeyCondition{Name: parts[0], Value: parts[1]}, nil
+}
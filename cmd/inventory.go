@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var inventoryOutput string
+
+var inventoryCmd = &cobra.Command{
+	Use:     "inventory",
+	Short:   "Summarize the backups in the bucket hour by hour, for one table or all of them",
+	PreRunE: checkRequiredInventoryFlags,
+	RunE:    showInventory,
+}
+
+func init() {
+	inventoryCmd.Flags().StringVarP(&inventoryOutput, "output", "o", "table", "Output format. One of: table, json")
+}
+
+func checkRequiredInventoryFlags(cmd *cobra.Command, args []string) error {
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	if inventoryOutput != "table" && inventoryOutput != "json" {
+		return errors.New("Error: Unknown output format " + inventoryOutput)
+	}
+	return nil
+}
+
+func showInventory(cmd *cobra.Command, args []string) error {
+	w, err := timeWindow()
+	if err != nil {
+		return err
+	}
+	a := newAws()
+	tables := []string{sourceTable}
+	if sourceTable == "" {
+		if tables, err = a.ListTables(); err != nil {
+			return err
+		}
+	}
+	var invs []*restore.TableInventory
+	for _, table := range tables {
+		inv, err := a.Inventory(table, w)
+		if err != nil {
+			return err
+		}
+		invs = append(invs, inv)
+	}
+	if inventoryOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(invs)
+	}
+	for _, inv := range invs {
+		fmt.Printf("%s: %d files, %d bytes, %d records\n", inv.Table, inv.Files, inv.Bytes, inv.Records)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOUR\tFILES\tBYTES\tRECORDS\tEARLIEST\tLATEST")
+		for _, hour := range inv.Hours {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\n", hour.Hour.Format(timeFlagLayout), hour.Files, hour.Bytes, hour.Records,
+				formatInventoryTime(hour.Earliest), formatInventoryTime(hour.Latest))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		for _, gap := range inv.Gaps {
+			fmt.Println("No backups for hour", gap.Format(timeFlagLayout))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func formatInventoryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+)
+
+var replayFrom string
+
+var replayDLQCmd = &cobra.Command{
+	Use:     "replay-dlq",
+	Short:   "Retry records a restore dead-lettered, once whatever made them fail is fixed",
+	PreRunE: checkRequiredReplayFlags,
+	RunE:    replayDeadLetters,
+}
+
+func init() {
+	replayDLQCmd.Flags().StringVarP(&replayFrom, "from", "", "", "Dead-letter file or s3://bucket/prefix to replay")
+	replayDLQCmd.Flags().StringVarP(&deadLetters, "dead-letters", "", "", "Keep entries that fail again in this JSON lines file or under s3://bucket/prefix")
+	replayDLQCmd.Flags().StringVarP(&tableMap, "map", "", "", "Target tables for records that failed to decode. Format: src1=dst1,src2=dst2")
+	replayDLQCmd.Flags().StringVarP(&targetSuffix, "target-suffix", "", "", "Replay records that failed to decode into their source table plus this suffix")
+}
+
+func checkRequiredReplayFlags(cmd *cobra.Command, args []string) error {
+	if replayFrom == "" {
+		return flagError("from")
+	}
+	if deadLetters != "" && deadLetters == replayFrom {
+		return errors.New("Error: Flag dead-letters has to be somewhere other than from")
+	}
+	return nil
+}
+
+func replayDeadLetters(cmd *cobra.Command, args []string) error {
+	mappings, err := tableMappings()
+	if err != nil {
+		return err
+	}
+	targets := map[string]string{}
+	for _, m := range mappings {
+		targets[m.Source] = m.Target
+	}
+	a := newAws()
+	entries, err := a.ReadDeadLetters(replayFrom)
+	if err != nil {
+		return err
+	}
+	// Sources only named in the dead letters still get a target from --target-suffix
+	if targetSuffix != "" {
+		for _, entry := range entries {
+			if _, ok := targets[entry.Table]; !ok {
+				targets[entry.Table] = entry.Table + targetSuffix
+			}
+		}
+	}
+	if deadLetters != "" {
+		dlq, err := openDeadLetters(a, deadLetters)
+		if err != nil {
+			return err
+		}
+		defer closeDeadLetters(dlq)
+		a.DeadLetters = dlq
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	logger.Info("Replaying dead letters", "from", replayFrom, "entries", len(entries))
+	stats, err := a.ReplayDeadLetters(ctx, entries, targets)
+	if stats != nil {
+		fmt.Printf("Replayed %d entries: %d written, %d failed\n", len(entries), stats.Written, stats.Failed)
+	}
+	if err == nil && stats.Failed > 0 {
+		err = fmt.Errorf("Error: %d entries failed again", stats.Failed)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/goamz/goamz/aws"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replicateCheckpoints  string
+	replicateOnce         bool
+	replicatePollInterval time.Duration
+	sourceRegion          string
+	targetRegion          string
+	targetCreds           credentialFlags
+)
+
+var replicateCmd = &cobra.Command{
+	Use:     "replicate",
+	Short:   "Apply changes from a table's DynamoDB stream straight to another table",
+	PreRunE: checkRequiredReplicateFlags,
+	RunE:    replicateTables,
+}
+
+func init() {
+	replicateCmd.Flags().StringVarP(&tableMap, "map", "", "", "Tables to replicate in one run. Format: src1=dst1,src2=dst2. Adds to the config file's tables section")
+	replicateCmd.Flags().StringVarP(&targetSuffix, "target-suffix", "", "", "Replicate each table without an explicit target into the source name plus this suffix")
+	replicateCmd.Flags().StringVarP(&replicateCheckpoints, "checkpoints", "", "replicate-checkpoints", "Directory or s3://bucket/prefix to keep how far each stream has been applied in")
+	replicateCmd.Flags().BoolVarP(&replicateOnce, "once", "", false, "Stop once caught up with the streams instead of following them until interrupted")
+	replicateCmd.Flags().DurationVarP(&replicatePollInterval, "poll-interval", "", 10*time.Second, "How long to wait between polls once caught up")
+	replicateCmd.Flags().StringVarP(&sourceRegion, "source-region", "", aws.USWest2.Name, "Region of the source tables")
+	replicateCmd.Flags().StringVarP(&targetRegion, "target-region", "", aws.USWest2.Name, "Region of the target tables")
+	targetCreds.addFlags(replicateCmd.Flags(), "target", "the target tables")
+	replicateCmd.Flags().StringVarP(&filterExpr, "filter", "", "", "Only apply records matching a DynamoDB style condition, e.g. 'eventName = \"REMOVE\" AND Keys.tenant = \"acme\"'")
+	replicateCmd.Flags().StringVarP(&execHook, "exec-hook", "", "", "Shell command to pipe records through as JSON lines, replying with a JSON array of records per line")
+	replicateCmd.Flags().StringVarP(&deadLetters, "dead-letters", "", "", "Keep records that can't be written in this JSON lines file or under s3://bucket/prefix, for replay-dlq")
+}
+
+func checkRequiredReplicateFlags(cmd *cobra.Command, args []string) error {
+	mappings, err := tableMappings()
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
+		return flagError("sourceTable")
+	}
+	for _, m := range mappings {
+		if m.Source == m.Target && sourceRegion == targetRegion && targetCreds.credentials() == nil {
+			return errors.New("Error: Replicating " + m.Source + " into itself would apply its changes again")
+		}
+	}
+	for _, region := range []string{sourceRegion, targetRegion} {
+		if _, ok := aws.Regions[region]; !ok {
+			return errors.New("Error: Unknown region " + region)
+		}
+	}
+	if filterExpr != "" {
+		if _, err := restore.ParseFilter(filterExpr); err != nil {
+			return err
+		}
+	}
+	transforms, err := configuredTransforms()
+	if err != nil {
+		return err
+	}
+	return transforms.Validate()
+}
+
+func replicateTables(cmd *cobra.Command, args []string) error {
+	mappings, err := tableMappings()
+	if err != nil {
+		return err
+	}
+	transforms, err := configuredTransforms()
+	if err != nil {
+		return err
+	}
+	var filter *restore.Filter
+	if filterExpr != "" {
+		if filter, err = restore.ParseFilter(filterExpr); err != nil {
+			return err
+		}
+	}
+	var sources, targets []string
+	for _, m := range mappings {
+		sources = append(sources, m.Source)
+		targets = append(targets, m.Target)
+	}
+	source := newAwsIn(aws.Regions[sourceRegion], sources...)
+	// The sources are read with --dynamo-* credentials, and the targets written with --target-* ones if given
+	targetAs := targetCreds.credentials()
+	if targetAs == nil {
+		targetAs = dynamoCreds.credentials()
+	}
+	target := newAwsAs(aws.Regions[targetRegion], targetAs, targets...)
+	if deadLetters != "" {
+		dlq, err := openDeadLetters(target, deadLetters)
+		if err != nil {
+			return err
+		}
+		defer closeDeadLetters(dlq)
+		target.DeadLetters = dlq
+	}
+	opts := []restore.Option{
+		restore.WithSource(source),
+		restore.WithSink(target),
+		restore.WithTables(mappings...),
+		restore.WithFilter(filter),
+		restore.WithTransforms(transforms),
+		restore.WithLogger(logger),
+	}
+	if execHook != "" {
+		hook, err := restore.NewExecProcessor(execHook)
+		if err != nil {
+			return err
+		}
+		defer hook.Close()
+		opts = append(opts, restore.WithProcessors(hook))
+	}
+	r, err := restore.NewRestorer(opts...)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := r.Replicate(ctx, source, restore.ReplicateOptions{
+		Follow:       !replicateOnce,
+		PollInterval: replicatePollInterval,
+		Checkpoints:  source.OpenCheckpoints(replicateCheckpoints),
+	})
+	if report != nil {
+		printRestoreReport(report)
+	}
+	return err
+}
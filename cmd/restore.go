@@ -1,12 +1,24 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/catherinetcai/s3-dynamo-restore/restore"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/goamz/goamz/aws"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 /*
@@ -21,13 +33,31 @@ Limitations:
 */
 
 var (
-	bucketName   string
-	bucketPrefix string
-	endTime      string
-	startTime    string
-	sourceTable  string
-	targetBucket string
-	targetTable  string
+	bucketName       string
+	baseSnapshot     string
+	bucketPrefix     string
+	conflictPolicy   string
+	concurrency      int
+	consistent       bool
+	deadLetters      string
+	dryRun           bool
+	execHook         string
+	exportLocation   string
+	filterExpr       string
+	follow           bool
+	followInterval   time.Duration
+	fromSnapshot     bool
+	metricsAddr      string
+	reportPath       string
+	progressInterval time.Duration
+	endTime          string
+	startTime        string
+	sourceTable      string
+	tableMap         string
+	targetBucket     string
+	targetSuffix     string
+	targetTable      string
+	versionAttribute string
 )
 
 var restoreCmd = &cobra.Command{
@@ -48,50 +78,444 @@ var getCmd = &cobra.Command{
 
 func init() {
 	s3Cmd.AddCommand(getCmd)
+	restoreCmd.Flags().StringVarP(&conflictPolicy, "conflict-policy", "", "", "Write items one by one with a condition instead of batch writing. One of: overwrite, skip-if-exists, only-if-version-older")
+	restoreCmd.Flags().StringVarP(&versionAttribute, "version-attribute", "", "", "Numeric attribute compared by the only-if-version-older conflict policy")
+	restoreCmd.Flags().StringVarP(&tableMap, "map", "", "", "Tables to restore in one run. Format: src1=dst1,src2=dst2. Adds to the config file's tables section")
+	restoreCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, "Read and transform backups and report what would be restored, without writing anything")
+	restoreCmd.Flags().StringVarP(&filterExpr, "filter", "", "", "Only restore records matching a DynamoDB style condition, e.g. 'eventName = \"REMOVE\" AND Keys.tenant = \"acme\"'")
+	restoreCmd.Flags().StringVarP(&execHook, "exec-hook", "", "", "Shell command to pipe records through as JSON lines, replying with a JSON array of records per line")
+	restoreCmd.Flags().IntVarP(&concurrency, "concurrency", "", 4, "Number of backup objects to read at once")
+	restoreCmd.Flags().BoolVarP(&consistent, "consistent", "", false, "Restore every table up to the latest instant all of their backups reach, so related tables stay consistent")
+	restoreCmd.Flags().DurationVarP(&progressInterval, "progress-interval", "", 30*time.Second, "How often to log progress when stderr isn't a terminal, which gets a live bar instead. 0 turns progress off")
+	restoreCmd.Flags().StringVarP(&metricsAddr, "metrics-addr", "", "", "Serve Prometheus metrics on this address, e.g. :9090, at /metrics while restoring")
+	restoreCmd.Flags().StringVarP(&reportPath, "report", "", "", "Write a JSON report of the restore to this file or s3://bucket/key")
+	restoreCmd.Flags().StringVarP(&deadLetters, "dead-letters", "", "", "Keep records that can't be decoded or written in this JSON lines file or under s3://bucket/prefix, for replay-dlq")
+	restoreCmd.Flags().StringVarP(&exportLocation, "export", "", "", "Restore a DynamoDB export to S3 instead of stream backups. Format: s3://bucket/prefix/AWSDynamoDB/exportId/")
+	restoreCmd.Flags().StringVarP(&baseSnapshot, "base-snapshot", "", "", "Restore a DynamoDB export first, then replay stream backups made after it up to --endTime. Format: s3://bucket/prefix/AWSDynamoDB/exportId/")
+	restoreCmd.Flags().BoolVarP(&fromSnapshot, "from-snapshot", "", false, "Start from the table's latest compacted snapshot and only replay the backups made after it")
+	restoreCmd.Flags().BoolVarP(&follow, "follow", "f", false, "After restoring, keep polling the backup prefix and applying new objects until interrupted")
+	restoreCmd.Flags().DurationVarP(&followInterval, "follow-interval", "", time.Minute, "How often to poll for new backups with --follow")
+	restoreCmd.Flags().StringVarP(&targetSuffix, "target-suffix", "", "", "Restore each table without an explicit target into the source name plus this suffix")
 }
 
 func checkRequiredRestoreFlags(cmd *cobra.Command, args []string) error {
-	if sourceTable == "" {
+	mappings, err := tableMappings()
+	if err != nil {
+		return err
+	}
+	if len(mappings) == 0 {
 		return flagError("sourceTable")
 	}
-	if targetTable == "" {
-		return flagError("targetTable")
+	if exportLocation != "" && baseSnapshot != "" {
+		return errors.New("Error: Pass either export or base-snapshot, not both")
+	}
+	if (exportLocation != "" || baseSnapshot != "") && len(mappings) != 1 {
+		return errors.New("Error: An export holds one table, so it restores into exactly one target")
+	}
+	if (baseSnapshot != "" || fromSnapshot) && startTime != "" {
+		return errors.New("Error: A base snapshot restore starts from the snapshot, so it can't take a startTime")
+	}
+	if exportLocation != "" && startTime != "" {
+		return errors.New("Error: Every exported item is stamped with the export time, so an export restore can't take a startTime")
+	}
+	if fromSnapshot && (exportLocation != "" || baseSnapshot != "") {
+		return errors.New("Error: Pass only one of export, base-snapshot and from-snapshot")
 	}
-	if bucketName == "" {
+	if follow && (exportLocation != "" || endTime != "" || dryRun) {
+		return errors.New("Error: follow keeps restoring stream backups as they're written, so it can't take an export, an endTime or a dry run")
+	}
+	if follow && consistent {
+		return errors.New("Error: follow applies every table's backups as they arrive, so it can't hold them to a consistent cut-off")
+	}
+	if exportLocation == "" && bucketName == "" {
 		return flagError("bucket")
 	}
-	return nil
+	if conflictPolicy != "" && !restore.IsValidConflictPolicy(conflictPolicy) {
+		return errors.New("Error: Unknown conflict policy " + conflictPolicy)
+	}
+	if restore.ConflictPolicy(conflictPolicy) == restore.ConflictVersionOlder && versionAttribute == "" {
+		return flagError("version-attribute")
+	}
+	if filterExpr != "" {
+		if _, err := restore.ParseFilter(filterExpr); err != nil {
+			return err
+		}
+	}
+	transforms, err := configuredTransforms()
+	if err != nil {
+		return err
+	}
+	return transforms.Validate()
+}
+
+// configuredTransforms reads the transforms section of the config file
+func configuredTransforms() (restore.Transforms, error) {
+	var transforms restore.Transforms
+	if err := viper.UnmarshalKey("transforms", &transforms); err != nil {
+		return nil, errors.New("Error: Unable to read transforms from config: " + err.Error())
+	}
+	return transforms, nil
+}
+
+// tableMappings gathers the tables to restore from the config file, --map and
+// --sourceTable/--targetTable, filling in missing targets with --target-suffix
+func tableMappings() ([]restore.TableMapping, error) {
+	targets := map[string]string{}
+	var sources []string
+	add := func(source, target string) {
+		if _, ok := targets[source]; !ok {
+			sources = append(sources, source)
+		}
+		targets[source] = target
+	}
+	configured := viper.GetStringMapString("tables")
+	var configuredSources []string
+	for source := range configured {
+		configuredSources = append(configuredSources, source)
+	}
+	sort.Strings(configuredSources)
+	for _, source := range configuredSources {
+		add(source, configured[source])
+	}
+	if tableMap != "" {
+		for _, pair := range strings.Split(tableMap, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if parts[0] == "" {
+				return nil, errors.New("Error: Flag map must be formatted as src1=dst1,src2=dst2")
+			}
+			if len(parts) == 1 {
+				parts = append(parts, "")
+			}
+			add(parts[0], parts[1])
+		}
+	}
+	if sourceTable != "" {
+		add(sourceTable, targetTable)
+	}
+	var mappings []restore.TableMapping
+	for _, source := range sources {
+		target := targets[source]
+		if target == "" {
+			if targetSuffix == "" {
+				return nil, errors.New("Error: No target table for " + source + ", set one or pass --target-suffix")
+			}
+			target = source + targetSuffix
+		}
+		mappings = append(mappings, restore.TableMapping{Source: source, Target: target})
+	}
+	return mappings, nil
 }
 
 func restoreFromBackup(cmd *cobra.Command, args []string) error {
-	a := newAws()
-	// Gets back all keys associated with the Table name
-	fmt.Println("Listing all keys from ", bucketName)
-	keys, err := a.ListWithPrefix(a.Config.Prefix + sourceTable + "/")
+	mappings, err := tableMappings()
+	if err != nil {
+		return err
+	}
+	w, err := timeWindow()
 	if err != nil {
 		return err
 	}
-	fmt.Println("Batch getting all keys...")
-	recs, err := a.BatchGet(keys)
+	transforms, err := configuredTransforms()
 	if err != nil {
 		return err
 	}
-	fmt.Println("Batch writing...")
-	err = a.BatchWrite(targetTable, recs)
+	if err = transforms.Validate(); err != nil {
+		return err
+	}
+	var filter *restore.Filter
+	if filterExpr != "" {
+		if filter, err = restore.ParseFilter(filterExpr); err != nil {
+			return err
+		}
+	}
+	var sources []string
+	for _, m := range mappings {
+		sources = append(sources, m.Source)
+	}
+	a := newAws(sources...)
+	if metricsAddr != "" {
+		a.Metrics = restore.NewMetrics()
+		shutdown := serveMetrics(metricsAddr, a.Metrics)
+		defer shutdown()
+	}
+	if deadLetters != "" {
+		dlq, err := openDeadLetters(a, deadLetters)
+		if err != nil {
+			return err
+		}
+		defer closeDeadLetters(dlq)
+		a.DeadLetters = dlq
+	}
+	var source restore.Source = a
+	if exportLocation != "" {
+		if source, err = exportSource(a, mappings[0].Target, exportLocation); err != nil {
+			return err
+		}
+	}
+	if baseSnapshot != "" {
+		base, err := exportSource(a, mappings[0].Target, baseSnapshot)
+		if err != nil {
+			return err
+		}
+		source = restore.NewIncrementalSource(base, a)
+		logger.Info("Replaying changes made after the base snapshot", "from", base.Summary.ExportTime, "to", describeTime(w.End, "the latest backup"))
+	}
+	if fromSnapshot {
+		if source, err = latestSnapshotSource(a, mappings, w); err != nil {
+			return err
+		}
+	}
+	opts := []restore.Option{
+		restore.WithSource(source),
+		restore.WithSink(a),
+		restore.WithTables(mappings...),
+		restore.WithWindow(w),
+		restore.WithConcurrency(concurrency),
+		restore.WithFilter(filter),
+		restore.WithTransforms(transforms),
+		restore.WithLogger(logger),
+	}
+	if conflictPolicy != "" {
+		logger.Info("Conditionally writing", "policy", conflictPolicy)
+		opts = append(opts, restore.WithSink(a.ConditionalSink(&restore.WritePolicy{
+			Policy:           restore.ConflictPolicy(conflictPolicy),
+			VersionAttribute: versionAttribute,
+		})))
+	}
+	if execHook != "" {
+		hook, err := restore.NewExecProcessor(execHook)
+		if err != nil {
+			return err
+		}
+		defer hook.Close()
+		opts = append(opts, restore.WithProcessors(hook))
+	}
+	if consistent {
+		opts = append(opts, restore.WithConsistentCutOff())
+	}
+	if dryRun {
+		opts = append(opts, restore.WithDryRun())
+		printRestorePlan(mappings, w, filter, transforms)
+	}
+	var progress *restore.Progress
+	if progressInterval > 0 {
+		progress = restore.NewProgress()
+		opts = append(opts, restore.WithProgress(progress))
+	}
+	r, err := restore.NewRestorer(opts...)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	stopProgress := func() {}
+	if progress != nil {
+		stopProgress = progress.Render(os.Stderr, logger, progressInterval)
+	}
+	report, err := r.Run(ctx)
+	stopProgress()
+	report.Parameters = flagParameters(cmd)
+	printRestoreReport(report)
+	if reportPath != "" {
+		if werr := writeReport(a, report, reportPath); werr != nil {
+			logger.Error("Error writing report", "path", reportPath, "error", werr)
+			if err == nil {
+				err = werr
+			}
+		}
+	}
+	if err != nil || !follow {
+		return err
+	}
+	return r.Follow(ctx, a, report, followInterval, printRestoreReport)
+}
+
+// exportSource reads an export, using the target table's key schema to pick out item keys
+func exportSource(a *restore.AWS, target, location string) (*restore.ExportSource, error) {
+	keys, err := a.KeyNames(target)
 	if err != nil {
+		return nil, err
+	}
+	export, err := a.NewExportSource(location, keys)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Restoring export", "table", export.Summary.TableName(), "format", export.Summary.OutputFormat,
+		"exportTime", export.Summary.ExportTime, "items", export.Summary.ItemCount)
+	return export, nil
+}
+
+// latestSnapshotSource layers backups over the table's latest compacted snapshot,
+// falling back to the backups alone if it hasn't been compacted yet
+func latestSnapshotSource(a *restore.AWS, mappings []restore.TableMapping, w restore.Window) (restore.Source, error) {
+	if len(mappings) != 1 {
+		return nil, errors.New("Error: Restoring from a snapshot works one table at a time")
+	}
+	manifest, err := a.LatestSnapshot(mappings[0].Source, w.End)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		logger.Warn("No snapshot to start from, replaying every backup", "table", mappings[0].Source)
+		return a, nil
+	}
+	logger.Info("Starting from snapshot", "manifest", manifest.Key, "cutOff", manifest.CutOff, "items", manifest.Items)
+	return restore.NewIncrementalSource(a.NewSnapshotSource(manifest), a), nil
+}
+
+// openDeadLetters opens a dead-letter file, or an S3 prefix given as s3://bucket/prefix
+func openDeadLetters(a *restore.AWS, location string) (restore.DeadLetterQueue, error) {
+	if bucket, prefix, ok := restore.ParseS3URL(location); ok {
+		return a.NewS3DeadLetters(bucket, prefix), nil
+	}
+	if strings.HasPrefix(location, "s3://") {
+		return nil, errors.New("Error: Dead-letter location must be formatted as s3://bucket/prefix")
+	}
+	return restore.NewFileDeadLetters(location)
+}
+
+func closeDeadLetters(dlq restore.DeadLetterQueue) {
+	if err := dlq.Close(); err != nil {
+		logger.Error("Error writing dead letters", "error", err)
+	}
+}
+
+// flagParameters lists the flags set on the command line
+func flagParameters(cmd *cobra.Command) map[string]string {
+	params := map[string]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		params[f.Name] = f.Value.String()
+	})
+	return params
+}
+
+// writeReport writes the report as JSON to a file or to s3://bucket/key
+func writeReport(a *restore.AWS, report *restore.Report, path string) error {
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
 		return err
 	}
-	return nil
+	if bucket, key, ok := restore.ParseS3URL(path); ok {
+		return a.Put(bucket, key, buf.Bytes(), "application/json")
+	}
+	if strings.HasPrefix(path, "s3://") {
+		return errors.New("Error: Report location must be formatted as s3://bucket/key")
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
 }
 
-func newAws() *restore.AWS {
+// serveMetrics serves metrics at /metrics in the background until shutdown is called
+func serveMetrics(addr string, metrics *restore.Metrics) (shutdown func()) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Error serving metrics", "addr", addr, "error", err)
+		}
+	}()
+	logger.Info("Serving metrics", "addr", addr)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}
+}
+
+// printRestorePlan lists what a dry run reads and how records are changed
+func printRestorePlan(mappings []restore.TableMapping, w restore.Window, filter *restore.Filter, transforms restore.Transforms) {
+	fmt.Println("Dry run plan:")
+	for _, m := range mappings {
+		fmt.Printf("  restore %s into %s\n", m.Source, m.Target)
+	}
+	switch {
+	case exportLocation != "":
+		fmt.Printf("  from the export at %s\n", exportLocation)
+	case baseSnapshot != "":
+		fmt.Printf("  from the export at %s, then changes made after it up to %s\n", baseSnapshot, describeTime(w.End, "the latest backup"))
+	default:
+		fmt.Printf("  from %s to %s\n", describeTime(w.Start, "the first backup"), describeTime(w.End, "the latest backup"))
+	}
+	if filter != nil {
+		fmt.Printf("  only records matching %s\n", filter)
+	}
+	if len(transforms) == 0 {
+		fmt.Println("  no transforms")
+	}
+	for i, t := range transforms {
+		fmt.Printf("  transform %d: %s\n", i+1, t)
+	}
+	if execHook != "" {
+		fmt.Printf("  then pipe records through %s\n", execHook)
+	}
+}
+
+func describeTime(t time.Time, open string) string {
+	if t.IsZero() {
+		return open
+	}
+	return t.Format(time.RFC3339)
+}
+
+// printRestoreReport prints one line per table
+func printRestoreReport(report *restore.Report) {
+	if !report.CutOff.IsZero() {
+		fmt.Println("Restored up to", report.CutOff.Format(time.RFC3339))
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTARGET\tOBJECTS\tRECORDS\tFILTERED\tWRITTEN\tCONFLICTS\tSKIPPED\tFAILED\tLAST SEQUENCE\tERROR")
+	for _, table := range report.Tables {
+		stats := table.Stats
+		if stats == nil {
+			stats = &restore.WriteStats{}
+		}
+		lastSeq, errMsg := "-", "-"
+		if table.LastSequenceNumber != "" {
+			lastSeq = table.LastSequenceNumber
+		}
+		if table.Error != "" {
+			errMsg = table.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", table.Source, table.Target, table.Objects, table.Records,
+			table.Filtered, stats.Written, stats.Conflicts, stats.Skipped, stats.Failed, lastSeq, errMsg)
+	}
+	tw.Flush()
+	fmt.Println("Finished in", report.Duration().Round(time.Second))
+}
+
+// newAws configures AWS for the given tables, or --sourceTable if there are none
+func newAws(tables ...string) *restore.AWS {
+	return newAwsIn(aws.USWest2, tables...)
+}
+
+// newAwsIn is newAws in a given region
+func newAwsIn(region aws.Region, tables ...string) *restore.AWS {
+	return newAwsAs(region, dynamoCreds.credentials(), tables...)
+}
+
+// newAwsAs is newAwsIn calling DynamoDB with the given credentials
+func newAwsAs(region aws.Region, dynamo *restore.Credentials, tables ...string) *restore.AWS {
+	if len(tables) == 0 {
+		tables = []string{sourceTable}
+	}
 	cfg := &restore.AWSConfig{
-		Bucket: bucketName,
-		Prefix: bucketPrefix,
-		Tables: []string{sourceTable},
-		Region: aws.USWest2,
+		Bucket:            bucketName,
+		Prefix:            bucketPrefix,
+		Tables:            tables,
+		Region:            region,
+		S3Credentials:     s3Creds.credentials(),
+		DynamoCredentials: dynamo,
+	}
+	a, err := restore.NewAWS(cfg)
+	if err != nil {
+		logger.Error("Error setting up AWS", "error", err)
+		os.Exit(-1)
+	}
+	a.Logger = logger
+	if a.Decryption, err = decryption(a); err != nil {
+		logger.Error("Error setting up decryption", "error", err)
+		os.Exit(-1)
 	}
-	a, _ := restore.NewAWS(cfg)
 	return a
 }
 
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	rollbackTable string
+	rollbackTo    string
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:     "rollback",
+	Short:   "Undo every change made to a table after a point in time",
+	PreRunE: checkRequiredRollbackFlags,
+	RunE:    rollbackChanges,
+}
+
+func init() {
+	rollbackCmd.Flags().StringVarP(&rollbackTable, "table", "", "", "Dynamo table to roll back in place")
+	rollbackCmd.Flags().StringVarP(&rollbackTo, "to", "", "", "Time point to roll the table back to. Format: YYYY-MM-DD-HH:MM")
+}
+
+func checkRequiredRollbackFlags(cmd *cobra.Command, args []string) error {
+	if rollbackTable == "" {
+		return flagError("table")
+	}
+	if rollbackTo == "" {
+		return flagError("to")
+	}
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	return nil
+}
+
+func rollbackChanges(cmd *cobra.Command, args []string) error {
+	to, err := parseTimeFlag("to", rollbackTo)
+	if err != nil {
+		return err
+	}
+	a := newAws(rollbackTable)
+	return a.Rollback(rollbackTable, to)
+}
@@ -17,18 +17,29 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-var cfgFile string
+var (
+	cfgFile       string
+	logFormat     string
+	logLevel      string
+	logUnredacted bool
+	logger        *slog.Logger
+)
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "s3-dynamo-restore",
 	Short: "Restore backup to dynamo from S3",
+	// Every command logs the same way
+	PersistentPreRunE: setupLogging,
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -42,9 +53,18 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	RootCmd.AddCommand(auditCmd)
+	RootCmd.AddCommand(backupCmd)
 	RootCmd.AddCommand(cloneCmd)
+	RootCmd.AddCommand(compactCmd)
+	RootCmd.AddCommand(historyCmd)
+	RootCmd.AddCommand(inventoryCmd)
+	RootCmd.AddCommand(replayDLQCmd)
+	RootCmd.AddCommand(replicateCmd)
 	RootCmd.AddCommand(restoreCmd)
+	RootCmd.AddCommand(rollbackCmd)
 	RootCmd.AddCommand(s3Cmd)
+	RootCmd.AddCommand(snapshotCmd)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports Persistent Flags, which, if defined here,
@@ -56,7 +76,14 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&bucketName, "bucket", "b", "", "Bucket name to read backups from")
 	RootCmd.PersistentFlags().StringVarP(&bucketPrefix, "prefix", "p", "/", "Bucket prefix that backups are written to")
 	RootCmd.PersistentFlags().StringVarP(&startTime, "startTime", "s", "", "Time point to restore backups from. Format: YYYY-MM-DD-HH:MM")
+	RootCmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", "text", "Log format. One of: text, json")
+	RootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "", "info", "Log level. One of: debug, info, warn, error")
+	RootCmd.PersistentFlags().BoolVarP(&logUnredacted, "log-unredacted", "", false, "Log item attribute values instead of only their names")
 	RootCmd.PersistentFlags().StringVarP(&endTime, "endTime", "e", "", "Time point to restore backups from. Format: YYYY-MM-DD-HH:MM")
+	s3Creds.addFlags(RootCmd.PersistentFlags(), "s3", "S3")
+	dynamoCreds.addFlags(RootCmd.PersistentFlags(), "dynamo", "DynamoDB")
+	addDecryptionFlags(RootCmd.PersistentFlags())
+	RootCmd.PersistentFlags().StringVarP(&roleSessionName, "role-session-name", "", "", "Session name for assumed roles, shown in CloudTrail. Defaults to s3-dynamo-restore")
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -78,6 +105,49 @@ func initConfig() {
 	}
 }
 
+// setupLogging logs to stderr, leaving stdout to command output
+func setupLogging(cmd *cobra.Command, args []string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return errors.New("Error: Unknown log level " + logLevel)
+	}
+	var err error
+	logger, err = restore.NewLogger(os.Stderr, logFormat, level, !logUnredacted)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
 func flagError(flag string) error {
 	return errors.New("Error: Missing required flag " + flag)
 }
+
+// Time flags share one format and are always in UTC, like the backup partitions
+const timeFlagLayout = "2006-01-02-15:04"
+
+func parseTimeFlag(flag, value string) (time.Time, error) {
+	t, err := time.Parse(timeFlagLayout, value)
+	if err != nil {
+		return time.Time{}, errors.New("Error: Flag " + flag + " must be formatted as YYYY-MM-DD-HH:MM")
+	}
+	return t, nil
+}
+
+// timeWindow reads the startTime and endTime flags, leaving unset ends open
+func timeWindow() (restore.Window, error) {
+	var w restore.Window
+	var err error
+	if startTime != "" {
+		if w.Start, err = parseTimeFlag("startTime", startTime); err != nil {
+			return w, err
+		}
+	}
+	if endTime != "" {
+		if w.End, err = parseTimeFlag("endTime", endTime); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
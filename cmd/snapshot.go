@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/catherinetcai/s3-dynamo-restore/restore"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotTable        string
+	snapshotSegments     int
+	snapshotReadCapacity float64
+	snapshotConsistent   bool
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:     "snapshot",
+	Short:   "Scan a table into a baseline snapshot restores can start from",
+	PreRunE: checkRequiredSnapshotFlags,
+	RunE:    snapshotTableScan,
+}
+
+func init() {
+	snapshotCmd.Flags().StringVarP(&snapshotTable, "table", "", "", "Dynamo table to snapshot")
+	snapshotCmd.Flags().IntVarP(&snapshotSegments, "segments", "", 4, "Number of table segments to scan at once")
+	snapshotCmd.Flags().Float64VarP(&snapshotReadCapacity, "read-capacity", "", 0, "Read capacity units a second to consume across all segments. Defaults to half the table's provisioned read capacity. On-demand tables aren't paced")
+	snapshotCmd.Flags().BoolVarP(&snapshotConsistent, "consistent-read", "", false, "Scan with strongly consistent reads, at twice the capacity")
+}
+
+func checkRequiredSnapshotFlags(cmd *cobra.Command, args []string) error {
+	if snapshotTable == "" {
+		return flagError("table")
+	}
+	if bucketName == "" {
+		return flagError("bucket")
+	}
+	return nil
+}
+
+func snapshotTableScan(cmd *cobra.Command, args []string) error {
+	a := newAws(snapshotTable)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	manifest, err := a.ScanSnapshot(ctx, snapshotTable, restore.ScanOptions{
+		Segments:       snapshotSegments,
+		ReadCapacity:   snapshotReadCapacity,
+		ConsistentRead: snapshotConsistent,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanned %d items in %s using %.1f read capacity units\n", manifest.Items,
+		manifest.Scan.EndTime.Sub(manifest.Scan.StartTime).Round(time.Second), manifest.Scan.CapacityUnits)
+	fmt.Println("Snapshot manifest:", manifest.Key)
+	return nil
+}
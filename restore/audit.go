@@ -0,0 +1,177 @@
+package restore
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// AuditIssueKind names a problem found in a table's backups
+type AuditIssueKind string
+
+const (
+	AuditMissingHour        AuditIssueKind = "missing-hour"
+	AuditCorruptObject      AuditIssueKind = "corrupt-object"
+	AuditBadRecord          AuditIssueKind = "bad-record"
+	AuditDuplicateObject    AuditIssueKind = "duplicate-object"
+	AuditDuplicateRecord    AuditIssueKind = "duplicate-record"
+	AuditSequenceRegression AuditIssueKind = "sequence-regression"
+	AuditMissingChange      AuditIssueKind = "missing-change"
+)
+
+// AuditIssue is one problem found in a table's backups
+type AuditIssue struct {
+	Kind   AuditIssueKind `json:"kind"`
+	Object string         `json:"object,omitempty"`
+	Line   int            `json:"line,omitempty"`
+	Detail string         `json:"detail"`
+	// Blocking issues mean the backup can't support a faithful restore
+	Blocking bool `json:"blocking"`
+}
+
+// AuditReport lists everything wrong with a table's backups
+type AuditReport struct {
+	Table   string       `json:"table"`
+	Objects int          `json:"objects"`
+	Records int          `json:"records"`
+	Issues  []AuditIssue `json:"issues"`
+}
+
+// CanRestore is true when none of the issues found get in the way of a faithful restore
+func (r *AuditReport) CanRestore() bool {
+	for _, issue := range r.Issues {
+		if issue.Blocking {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AuditReport) add(issue AuditIssue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+// Audit reads every backup object of a table in the window and checks that
+// together they hold an unbroken history of changes
+func (a *AWS) Audit(table string, w Window) (*AuditReport, error) {
+	objs, err := a.ListPartitionObjects(table, w)
+	if err != nil {
+		return nil, err
+	}
+	report := &AuditReport{Table: table, Objects: len(objs)}
+	prefix := a.tablePrefix(table)
+	var hours []time.Time
+	etags := map[string]string{}
+	var recs StreamRecordWrappers
+	for _, obj := range objs {
+		hour, _ := partitionTime(prefix, obj.Key)
+		if len(hours) == 0 || !hours[len(hours)-1].Equal(hour) {
+			hours = append(hours, hour)
+		}
+		etag := fmt.Sprintf("%s/%d", obj.ETag, obj.Size)
+		if original, ok := etags[etag]; ok {
+			report.add(AuditIssue{Kind: AuditDuplicateObject, Object: obj.Key, Detail: "same content as " + original})
+		} else {
+			etags[etag] = obj.Key
+		}
+		objRecs, err := a.auditObject(obj.Key, report)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, objRecs...)
+	}
+	for _, hour := range missingHours(hours, w) {
+		// A quiet table has hours without changes, so gaps alone don't mean anything was lost
+		report.add(AuditIssue{Kind: AuditMissingHour, Detail: "no backups for " + hour.Format(partitionLayout)})
+	}
+	report.Records = len(recs)
+	auditSequences(recs, report)
+	return report, nil
+}
+
+// auditObject decodes one object, reporting lines that don't decode and objects that are cut short
+func (a *AWS) auditObject(key string, report *AuditReport) (StreamRecordWrappers, error) {
+	r, err := a.open(key)
+	if err != nil {
+		if err != gzip.ErrHeader && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		// The gzip header itself is cut short or mangled
+		report.add(AuditIssue{Kind: AuditCorruptObject, Object: key, Detail: err.Error(), Blocking: true})
+		return nil, nil
+	}
+	defer r.Close()
+	recs, err := readRecords(r, func(line int, raw []byte, err error) {
+		report.add(AuditIssue{Kind: AuditBadRecord, Object: key, Line: line, Detail: err.Error(), Blocking: true})
+	})
+	if err != nil {
+		// Truncated or corrupt gzip members surface as read errors part way through
+		report.add(AuditIssue{Kind: AuditCorruptObject, Object: key, Detail: err.Error(), Blocking: true})
+	}
+	return recs, nil
+}
+
+// auditSequences checks the order and continuity of every key's changes. Backup
+// lines don't carry the shard they were read from, but all changes to a key go
+// through one shard at a time, so checking per key checks each shard's sequence
+func auditSequences(recs StreamRecordWrappers, report *AuditReport) {
+	byKey := map[string]StreamRecordWrappers{}
+	var keys []string
+	for _, rec := range recs {
+		id := rec.KeyID()
+		if _, ok := byKey[id]; !ok {
+			keys = append(keys, id)
+		}
+		byKey[id] = append(byKey[id], rec)
+	}
+	for _, id := range keys {
+		changes := byKey[id]
+		sort.Stable(bySequenceNumber(changes))
+		for i := 1; i < len(changes); i++ {
+			prev, cur := changes[i-1], changes[i]
+			seq := aws.StringValue(cur.SequenceNumber)
+			if compareSequenceNumbers(prev.SequenceNumber, cur.SequenceNumber) == 0 {
+				report.add(AuditIssue{Kind: AuditDuplicateRecord, Detail: "sequence number " + seq + " of key " + id + " was backed up more than once"})
+				continue
+			}
+			if cur.ApproximateCreationDateTime.Before(*prev.ApproximateCreationDateTime) {
+				report.add(AuditIssue{Kind: AuditSequenceRegression, Detail: "sequence number " + seq + " of key " + id + " was made before the change it follows", Blocking: true})
+			}
+			if !continues(prev, cur) {
+				report.add(AuditIssue{Kind: AuditMissingChange, Detail: "change " + seq + " of key " + id + " doesn't start from the image left by " + aws.StringValue(prev.SequenceNumber), Blocking: true})
+			}
+		}
+	}
+}
+
+// continues checks that a change starts from the image the previous change left behind
+func continues(prev, cur *StreamRecordWrapper) bool {
+	var left map[string]*dynamodb.AttributeValue
+	if prev.isInsertOrModifyOperation() {
+		left = prev.NewImage
+	}
+	if cur.EventName == dynamodbstreams.OperationTypeInsert {
+		return len(left) == 0
+	}
+	if len(cur.OldImage) == 0 {
+		// The stream didn't capture old images, so there's nothing to compare
+		return true
+	}
+	return reflect.DeepEqual(left, cur.OldImage)
+}
+
+// bySequenceNumber sorts one key's changes in stream order
+type bySequenceNumber StreamRecordWrappers
+
+func (s bySequenceNumber) Len() int { return len(s) }
+func (s bySequenceNumber) Less(i, j int) bool {
+	return compareSequenceNumbers(s[i].SequenceNumber, s[j].SequenceNumber) < 0
+}
+func (s bySequenceNumber) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -1,7 +1,10 @@
 package restore
 
 import (
+	"log/slog"
+
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	gaws "github.com/goamz/goamz/aws"
 	"github.com/goamz/goamz/s3"
 )
@@ -10,7 +13,19 @@ import (
 type AWS struct {
 	Bucket *s3.Bucket
 	Dynamo *dynamodb.DynamoDB
-	Config *AWSConfig
+	// Streams reads DynamoDB streams for backups
+	Streams *dynamodbstreams.DynamoDBStreams
+	Config  *AWSConfig
+	// Logger defaults to slog's default logger with item values redacted
+	Logger *slog.Logger
+	// Metrics, if set, counts objects read and items written
+	Metrics *Metrics
+	// DeadLetters, if set, receives records that couldn't be decoded or written
+	DeadLetters DeadLetterQueue
+	// Decryption, if set, reads backup objects that were encrypted client side or with SSE-C
+	Decryption *Decryption
+
+	s3Auth *s3Auth
 }
 
 // AWSConfig wraps s3 bucket and dynamo tables
@@ -19,13 +34,36 @@ type AWSConfig struct {
 	Prefix string
 	Tables []string
 	Region gaws.Region
+	// S3Credentials, if set, reads and writes S3 as another identity, such as a role in the account holding the backups
+	S3Credentials *Credentials
+	// DynamoCredentials, if set, calls DynamoDB and its streams as another identity, such as a role in the target's account
+	DynamoCredentials *Credentials
 }
 
 // NewAWS creates struct that wraps AWS configs
 func NewAWS(cfg *AWSConfig) (*AWS, error) {
-	s3Svc, err := s3Svc(cfg)
+	auth, err := newS3Auth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var s3Svc *s3.S3
+	if auth != nil {
+		s3Svc, err = auth.service()
+	} else {
+		s3Svc, err = sharedS3Svc(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess, err := awsSession(cfg, cfg.DynamoCredentials)
 	if err != nil {
 		return nil, err
 	}
-	return &AWS{s3Svc.Bucket(cfg.Bucket), dynamo(cfg), cfg}, nil
+	return &AWS{
+		Bucket:  s3Svc.Bucket(cfg.Bucket),
+		Dynamo:  dynamodb.New(sess),
+		Streams: dynamodbstreams.New(sess),
+		Config:  cfg,
+		s3Auth:  auth,
+	}, nil
 }
@@ -0,0 +1,89 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	backupCheckpoint = "backup"
+	// backupObjectRecords is how many records a shard buffers before writing them out
+	backupObjectRecords = 10000
+	// defaultBackupFlushInterval is how long a followed shard's records are held by default
+	defaultBackupFlushInterval = time.Minute
+)
+
+// BackupOptions configures Backup
+type BackupOptions struct {
+	// Follow keeps polling the stream until ctx is done instead of stopping once caught up
+	Follow bool
+	// PollInterval is how long to wait between polls once caught up, defaulting to 10s
+	PollInterval time.Duration
+	// FlushInterval is how long a caught up shard's records are held for more to
+	// arrive while following, so a quiet table isn't written a few records at a
+	// time. It defaults to a minute
+	FlushInterval time.Duration
+}
+
+// BackupStats counts what a backup wrote
+type BackupStats struct {
+	Records int `json:"records"`
+	Objects int `json:"objects"`
+}
+
+// Backup reads a table's stream and writes its records to S3 in the same hourly
+// layout and format restores read. Shards are read after their parents, and
+// the position in each is checkpointed in S3 after every object written, so an
+// interrupted backup picks up where it left off. Records written just before
+// an interruption may be written again, which restores tolerate. While
+// following, a shard's records are written once there are enough for an object
+// or they've waited FlushInterval
+func (a *AWS) Backup(ctx context.Context, table string, opts BackupOptions) (*BackupStats, error) {
+	stats := &BackupStats{}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultBackupFlushInterval
+	}
+	t := &streamTail{
+		table:       table,
+		checkpoints: a.NewS3Checkpoints(a.Config.Bucket, a.tablePrefix(table)+checkpointDir),
+		name:        backupCheckpoint,
+		batch:       backupObjectRecords,
+		maxAge:      opts.FlushInterval,
+		handle: func(ctx context.Context, shardID string, recs StreamRecordWrappers) error {
+			return a.writeBackup(table, shardID, recs, stats)
+		},
+	}
+	if err := a.tailStream(ctx, t, opts.Follow, opts.PollInterval); err != nil {
+		return stats, err
+	}
+	a.logger().Info("Backup caught up", "table", table, "records", stats.Records, "objects", stats.Objects)
+	return stats, nil
+}
+
+// writeBackup writes a shard's records into the hourly partitions they were made in
+func (a *AWS) writeBackup(table, shardID string, recs StreamRecordWrappers, stats *BackupStats) error {
+	var hours []time.Time
+	byHour := map[time.Time]StreamRecordWrappers{}
+	for _, rec := range recs {
+		hour := rec.ApproximateCreationDateTime.UTC().Truncate(time.Hour)
+		if _, ok := byHour[hour]; !ok {
+			hours = append(hours, hour)
+		}
+		byHour[hour] = append(byHour[hour], rec)
+	}
+	for _, hour := range hours {
+		hourRecs := byHour[hour]
+		key := fmt.Sprintf("%s%s/%s-%s.json%s", a.tablePrefix(table), hour.Format(partitionLayout), shardID,
+			aws.StringValue(hourRecs[0].SequenceNumber), gzipExt)
+		if _, err := a.putRecords(key, hourRecs); err != nil {
+			return err
+		}
+		stats.Records += len(hourRecs)
+		stats.Objects++
+		a.logger().Debug("Wrote backup object", "table", table, "key", key, "records", len(hourRecs))
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ConflictPolicy decides what happens when a restored item collides with a live one
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite writes every record unconditionally
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkipIfExists only writes items that are not already in the table
+	ConflictSkipIfExists ConflictPolicy = "skip-if-exists"
+	// ConflictVersionOlder only writes items whose live version is older than the backup
+	ConflictVersionOlder ConflictPolicy = "only-if-version-older"
+)
+
+// IsValidConflictPolicy checks a policy name given on the command line
+func IsValidConflictPolicy(policy string) bool {
+	switch ConflictPolicy(policy) {
+	case ConflictOverwrite, ConflictSkipIfExists, ConflictVersionOlder:
+		return true
+	}
+	return false
+}
+
+// WritePolicy configures conditional writes into a live table
+type WritePolicy struct {
+	Policy ConflictPolicy
+	// VersionAttribute is the numeric attribute compared by ConflictVersionOlder
+	VersionAttribute string
+}
+
+// maxFailedKeys caps how many failed keys WriteStats lists, so huge failures don't blow up reports
+const maxFailedKeys = 1000
+
+// WriteStats counts what happened to records written to a table
+type WriteStats struct {
+	Written   int `json:"written"`
+	Conflicts int `json:"conflicts"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+	// Superseded counts records dropped in compaction since a later change to the same key replaced them
+	Superseded    int     `json:"superseded"`
+	CapacityUnits float64 `json:"capacityUnits"`
+	// FailedKeys lists the keys of the first failed items as DynamoDB JSON
+	FailedKeys []json.RawMessage `json:"failedKeys,omitempty"`
+}
+
+// Add sums stats from another write into s
+func (s *WriteStats) Add(other *WriteStats) {
+	if other == nil {
+		return
+	}
+	s.Written += other.Written
+	s.Conflicts += other.Conflicts
+	s.Skipped += other.Skipped
+	s.Failed += other.Failed
+	s.Superseded += other.Superseded
+	s.CapacityUnits += other.CapacityUnits
+	for _, key := range other.FailedKeys {
+		if len(s.FailedKeys) < maxFailedKeys {
+			s.FailedKeys = append(s.FailedKeys, key)
+		}
+	}
+}
+
+func (s *WriteStats) addFailedKey(key map[string]*dynamodb.AttributeValue) {
+	if len(s.FailedKeys) >= maxFailedKeys {
+		return
+	}
+	if raw, err := MarshalAttributeValues(key); err == nil {
+		s.FailedKeys = append(s.FailedKeys, raw)
+	}
+}
+
+// ConditionalWrite writes records one item at a time, guarding each write with the policy's condition
+func (a *AWS) ConditionalWrite(targetTable string, recs StreamRecordWrappers, policy *WritePolicy) (*WriteStats, error) {
+	return a.ConditionalWriteWithContext(context.Background(), targetTable, recs, policy)
+}
+
+// ConditionalWriteWithContext is ConditionalWrite, stopping between items once ctx is done
+func (a *AWS) ConditionalWriteWithContext(ctx context.Context, targetTable string, recs StreamRecordWrappers, policy *WritePolicy) (*WriteStats, error) {
+	if policy.Policy == ConflictVersionOlder && policy.VersionAttribute == "" {
+		return nil, errors.New("Error: " + string(ConflictVersionOlder) + " requires a version attribute")
+	}
+	_, err := a.getTable(targetTable)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(recs)
+	a.logger().Debug("Removing duplicate records", "table", targetTable)
+	stats := &WriteStats{Superseded: len(recs)}
+	recs.RemoveDupes()
+	stats.Superseded -= len(recs)
+	progress := ProgressFromContext(ctx)
+	progress.Compacted(len(recs))
+	for _, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		var units float64
+		if rec.isInsertOrModifyOperation() {
+			units, err = a.conditionalPut(ctx, targetTable, rec, policy)
+		} else {
+			if policy.Policy == ConflictSkipIfExists {
+				// Deleting would touch an existing item, which is exactly what this policy avoids
+				stats.Skipped++
+				continue
+			}
+			if policy.Policy == ConflictVersionOlder && rec.OldImage[policy.VersionAttribute] == nil {
+				// Without the deleted version there's no telling whether the live item is newer, so
+				// the delete is skipped rather than risk removing a later write. Streams that don't
+				// carry old images, KEYS_ONLY and NEW_IMAGE, land here for every delete
+				stats.Skipped++
+				continue
+			}
+			units, err = a.conditionalDelete(ctx, targetTable, rec, policy)
+		}
+		switch {
+		case err == nil:
+			stats.Written++
+			stats.CapacityUnits += units
+			progress.Wrote(1, units)
+			a.Metrics.itemWritten(targetTable, units)
+		case isConditionalCheckFailed(err):
+			stats.Conflicts++
+		default:
+			a.Metrics.writeError(targetTable, err)
+			a.Metrics.itemsFailedToWrite(targetTable, 1)
+			a.logger().Warn("Error writing item", "table", targetTable, "key", Item(rec.Keys), "error", err)
+			stats.Failed++
+			stats.addFailedKey(rec.Keys)
+			// The SDK has already retried the item, so it's dead-lettered after one attempt of ours
+			a.deadLetterWrites(targetTable, WriteRequests{rec.CreateWriteRequest()}, err.Error(), 1)
+		}
+	}
+	a.logger().Info("Conditional write finished", "table", targetTable,
+		"written", stats.Written, "conflicts", stats.Conflicts, "skipped", stats.Skipped, "failed", stats.Failed)
+	return stats, nil
+}
+
+func (a *AWS) conditionalPut(ctx context.Context, targetTable string, rec *StreamRecordWrapper, policy *WritePolicy) (float64, error) {
+	input := &dynamodb.PutItemInput{
+		TableName:              aws.String(targetTable),
+		Item:                   rec.NewImage,
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = rec.writeCondition(policy, rec.NewImage, "<")
+	res, err := a.Dynamo.PutItemWithContext(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	return consumedCapacity(res.ConsumedCapacity), nil
+}
+
+func (a *AWS) conditionalDelete(ctx context.Context, targetTable string, rec *StreamRecordWrapper, policy *WritePolicy) (float64, error) {
+	input := &dynamodb.DeleteItemInput{
+		TableName:              aws.String(targetTable),
+		Key:                    rec.Keys,
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	// A delete is only safe if the live item hasn't moved past the version that was deleted
+	input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues = rec.writeCondition(policy, rec.OldImage, "<=")
+	res, err := a.Dynamo.DeleteItemWithContext(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	return consumedCapacity(res.ConsumedCapacity), nil
+}
+
+// writeCondition builds the condition expression guarding a single item write
+func (s *StreamRecordWrapper) writeCondition(policy *WritePolicy, image map[string]*dynamodb.AttributeValue, op string) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	names := map[string]*string{"#k": aws.String(s.KeyName())}
+	switch policy.Policy {
+	case ConflictSkipIfExists:
+		return aws.String("attribute_not_exists(#k)"), names, nil
+	case ConflictVersionOlder:
+		version, ok := image[policy.VersionAttribute]
+		if !ok {
+			// Without a version in the backup there's nothing to compare, so only fill in missing
+			// items. Deletes with no version never get here, since they're skipped
+			return aws.String("attribute_not_exists(#k)"), names, nil
+		}
+		names["#v"] = aws.String(policy.VersionAttribute)
+		values := map[string]*dynamodb.AttributeValue{":v": version}
+		return aws.String("attribute_not_exists(#k) OR #v " + op + " :v"), names, values
+	}
+	return nil, nil, nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	}
+	return false
+}
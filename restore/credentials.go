@@ -0,0 +1,100 @@
+package restore
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	gaws "github.com/goamz/goamz/aws"
+	"github.com/goamz/goamz/s3"
+)
+
+const defaultRoleSessionName = "s3-dynamo-restore"
+
+// Credentials picks the identity calls to one service are made as, so backups
+// can be read in one account and tables written in another
+type Credentials struct {
+	// Profile names a profile in the shared config and credentials files. Empty uses the environment's
+	Profile string
+	// RoleArn, if set, is assumed with the profile's credentials
+	RoleArn string
+	// ExternalID is passed when assuming the role, for roles whose trust policy requires one
+	ExternalID string
+	// SessionName names the assumed role session in CloudTrail, defaulting to s3-dynamo-restore
+	SessionName string
+	// Duration is how long each assumed role session lasts, defaulting to 15 minutes. Sessions are renewed before they expire
+	Duration time.Duration
+}
+
+// awsSession opens a session in the configured region as creds, or with the
+// environment's credentials if creds is nil
+func awsSession(cfg *AWSConfig, creds *Credentials) (*session.Session, error) {
+	opts := session.Options{Config: aws.Config{Region: aws.String(cfg.Region.Name)}}
+	if creds == nil {
+		return session.NewSessionWithOptions(opts)
+	}
+	// Profiles can be defined in the config file as well as the credentials file
+	opts.Profile = creds.Profile
+	opts.SharedConfigState = session.SharedConfigEnable
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil || creds.RoleArn == "" {
+		return sess, err
+	}
+	assumed := stscreds.NewCredentials(sess, creds.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = creds.SessionName
+		if p.RoleSessionName == "" {
+			p.RoleSessionName = defaultRoleSessionName
+		}
+		if creds.ExternalID != "" {
+			p.ExternalID = aws.String(creds.ExternalID)
+		}
+		if creds.Duration > 0 {
+			p.Duration = creds.Duration
+		}
+	})
+	return sess.Copy(&aws.Config{Credentials: assumed}), nil
+}
+
+// s3Auth signs S3 requests as the configured S3 credentials. goamz falls back
+// to the environment's credentials once a session token expires, so with
+// explicit credentials every bucket handle is made from freshly renewed ones
+type s3Auth struct {
+	region gaws.Region
+	creds  *credentials.Credentials
+}
+
+// newS3Auth sets up signing for S3, returning nil to keep using goamz's shared credentials
+func newS3Auth(cfg *AWSConfig) (*s3Auth, error) {
+	if cfg.S3Credentials == nil {
+		return nil, nil
+	}
+	sess, err := awsSession(cfg, cfg.S3Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Auth{region: cfg.Region, creds: sess.Config.Credentials}, nil
+}
+
+func (s *s3Auth) service() (*s3.S3, error) {
+	v, err := s.creds.Get()
+	if err != nil {
+		return nil, err
+	}
+	// The handle only lives for one call, well inside the credentials' lifetime
+	auth := gaws.NewAuth(v.AccessKeyID, v.SecretAccessKey, v.SessionToken, time.Now().Add(time.Hour))
+	return s3.New(*auth, s.region), nil
+}
+
+// bucket is a handle on any bucket the S3 credentials can reach
+func (a *AWS) bucket(name string) (*s3.Bucket, error) {
+	if a.s3Auth == nil {
+		return a.Bucket.S3.Bucket(name), nil
+	}
+	svc, err := a.s3Auth.service()
+	if err != nil {
+		return nil, err
+	}
+	return svc.Bucket(name), nil
+}
@@ -0,0 +1,323 @@
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DeadLetterKind says what went wrong with a dead-lettered record
+type DeadLetterKind string
+
+const (
+	// DeadLetterDecode is a backup line that couldn't be decoded
+	DeadLetterDecode DeadLetterKind = "decode"
+	// DeadLetterWrite is a write request DynamoDB didn't accept
+	DeadLetterWrite DeadLetterKind = "write"
+)
+
+// deadLettersPerObject is how many entries an S3 dead-letter object holds before a new one is started
+const deadLettersPerObject = 1000
+
+// DeadLetter is a record that couldn't be decoded or written, with enough context to retry it
+type DeadLetter struct {
+	Kind DeadLetterKind `json:"kind"`
+	// Format is the export format for decode entries from an export, and empty for stream backup lines
+	Format string `json:"format,omitempty"`
+	// Table is the backed up table for decode entries and the target table for write entries
+	Table  string `json:"table"`
+	Object string `json:"object,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	// Raw is the backup line, for decode entries
+	Raw string `json:"raw,omitempty"`
+	// Request is the write request as DynamoDB JSON, for write entries
+	Request  json.RawMessage `json:"request,omitempty"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts,omitempty"`
+	Time     time.Time       `json:"time"`
+}
+
+// DeadLetterQueue keeps records that couldn't be decoded or written
+type DeadLetterQueue interface {
+	Put(entry *DeadLetter) error
+	// Close flushes anything buffered
+	Close() error
+}
+
+// FileDeadLetters appends entries to a local JSON lines file
+type FileDeadLetters struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeadLetters opens path for appending, creating it if needed
+func NewFileDeadLetters(path string) (*FileDeadLetters, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetters{file: f}, nil
+}
+
+// Put appends one entry
+func (d *FileDeadLetters) Put(entry *DeadLetter) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err = d.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (d *FileDeadLetters) Close() error {
+	return d.file.Close()
+}
+
+// S3DeadLetters writes entries as JSON lines objects under a prefix
+type S3DeadLetters struct {
+	a      *AWS
+	bucket string
+	prefix string
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	count  int
+}
+
+// NewS3DeadLetters writes entries under s3://bucket/prefix
+func (a *AWS) NewS3DeadLetters(bucket, prefix string) *S3DeadLetters {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3DeadLetters{a: a, bucket: bucket, prefix: prefix}
+}
+
+// Put buffers one entry, writing an object once enough have built up
+func (d *S3DeadLetters) Put(entry *DeadLetter) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf.Write(append(line, '\n'))
+	d.count++
+	if d.count >= deadLettersPerObject {
+		return d.flush()
+	}
+	return nil
+}
+
+// Close writes whatever is still buffered
+func (d *S3DeadLetters) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flush()
+}
+
+func (d *S3DeadLetters) flush() error {
+	if d.count == 0 {
+		return nil
+	}
+	key := d.prefix + time.Now().UTC().Format("20060102T150405.000000000Z") + ".jsonl"
+	if err := d.a.Put(d.bucket, key, d.buf.Bytes(), "application/x-ndjson"); err != nil {
+		return err
+	}
+	d.buf.Reset()
+	d.count = 0
+	return nil
+}
+
+// deadLetter hands an entry to the dead-letter queue if there is one
+func (a *AWS) deadLetter(entry *DeadLetter) {
+	if a.DeadLetters == nil {
+		return
+	}
+	entry.Time = time.Now()
+	if err := a.DeadLetters.Put(entry); err != nil {
+		a.logger().Error("Error dead-lettering record", "table", entry.Table, "kind", entry.Kind, "error", err)
+	}
+}
+
+// deadLetterWrites dead-letters write requests DynamoDB didn't accept
+func (a *AWS) deadLetterWrites(table string, wrs WriteRequests, cause string, attempts int) {
+	for _, wr := range wrs {
+		req, err := MarshalAttributeValues(wr)
+		if err != nil {
+			a.logger().Error("Error encoding failed write request", "table", table, "error", err)
+			continue
+		}
+		a.deadLetter(&DeadLetter{Kind: DeadLetterWrite, Table: table, Request: req, Error: cause, Attempts: attempts})
+	}
+}
+
+// ReadDeadLetters reads the entries in a JSON lines file, or in every object under s3://bucket/prefix
+func (a *AWS) ReadDeadLetters(location string) ([]*DeadLetter, error) {
+	bucket, prefix, ok := ParseS3URL(location)
+	if !ok {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return decodeDeadLetters(location, f)
+	}
+	b, err := a.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := listKeys(b, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+	var entries []*DeadLetter
+	for _, key := range keys {
+		body, err := b.Get(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		objEntries, err := decodeDeadLetters(key.Key, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, objEntries...)
+	}
+	return entries, nil
+}
+
+// decodeBackupLine decodes a dead-lettered stream backup line
+func decodeBackupLine(raw string) (*StreamRecordWrapper, error) {
+	rec := &StreamRecordWrapper{}
+	if err := json.Unmarshal([]byte(raw), rec); err != nil {
+		return nil, err
+	}
+	if len(rec.Keys) == 0 {
+		// Valid JSON that isn't a stream record
+		return nil, errors.New("no keys")
+	}
+	return rec, nil
+}
+
+func decodeDeadLetters(name string, r io.Reader) ([]*DeadLetter, error) {
+	var entries []*DeadLetter
+	scanner := bufio.NewScanner(r)
+	// Dead-lettered items can be up to DynamoDB's 400KB item limit and then some
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("Error: %s line %d: %v", name, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ReplayDeadLetters retries dead-lettered records. Decode entries are decoded
+// again and written into targets[entry.Table]: stream backup lines as stream
+// records, and export lines as items keyed by the target's key schema. Ion
+// export objects that couldn't be parsed are skipped, since they have to be
+// restored from the export again. Write entries are sent to their table as
+// they are. Entries that fail again go to the dead-letter queue, if set
+func (a *AWS) ReplayDeadLetters(ctx context.Context, entries []*DeadLetter, targets map[string]string) (*WriteStats, error) {
+	recs := map[string]StreamRecordWrappers{}
+	requests := map[string]WriteRequests{}
+	keyNames := map[string][]string{}
+	// Tables are replayed in the order they first appear
+	var recTables, requestTables []string
+	stats := &WriteStats{}
+	for _, entry := range entries {
+		switch entry.Kind {
+		case DeadLetterDecode:
+			target, ok := targets[entry.Table]
+			if !ok {
+				return nil, errors.New("Error: No target table for dead-lettered records of " + entry.Table)
+			}
+			var rec *StreamRecordWrapper
+			var err error
+			switch entry.Format {
+			case "":
+				rec, err = decodeBackupLine(entry.Raw)
+			case ExportFormatJSON:
+				if _, ok := keyNames[target]; !ok {
+					if keyNames[target], err = a.KeyNames(target); err != nil {
+						return nil, err
+					}
+				}
+				var item map[string]*dynamodb.AttributeValue
+				if item, err = decodeExportLine([]byte(entry.Raw)); err == nil {
+					rec = insertRecord(item, keyNames[target], entry.Time, exportSequenceNumber)
+				}
+			case ExportFormatIon:
+				a.logger().Warn("Ion export objects can't be replayed from a dead letter, restore the object from the export once it's fixed",
+					"object", entry.Object)
+				stats.Skipped++
+				continue
+			default:
+				return nil, errors.New("Error: Unknown dead-letter format " + entry.Format)
+			}
+			if err != nil {
+				a.logger().Warn("Dead-lettered line still doesn't decode", "object", entry.Object, "line", entry.Line, "error", err)
+				stats.Failed++
+				retry := *entry
+				retry.Error, retry.Attempts = err.Error(), entry.Attempts+1
+				a.deadLetter(&retry)
+				continue
+			}
+			if _, ok := recs[target]; !ok {
+				recTables = append(recTables, target)
+			}
+			recs[target] = append(recs[target], rec)
+		case DeadLetterWrite:
+			wr := &dynamodb.WriteRequest{}
+			if err := json.Unmarshal(entry.Request, wr); err != nil {
+				return nil, fmt.Errorf("Error: Unable to decode dead-lettered write request for %s: %v", entry.Table, err)
+			}
+			if _, ok := requests[entry.Table]; !ok {
+				requestTables = append(requestTables, entry.Table)
+			}
+			requests[entry.Table] = append(requests[entry.Table], wr)
+		default:
+			return nil, errors.New("Error: Unknown dead-letter kind " + string(entry.Kind))
+		}
+	}
+	for _, table := range recTables {
+		tableStats, err := a.BatchWriteWithContext(ctx, table, recs[table])
+		stats.Add(tableStats)
+		if err != nil {
+			return stats, err
+		}
+	}
+	for _, table := range requestTables {
+		wrs := requests[table]
+		for i := 0; i < len(wrs); i += BatchWriteItemSizeLimit {
+			if err := ctx.Err(); err != nil {
+				return stats, err
+			}
+			end := i + BatchWriteItemSizeLimit
+			if end > len(wrs) {
+				end = len(wrs)
+			}
+			written, capacity, failed := a.writeBatch(ctx, table, wrs[i:end])
+			stats.Written += written
+			stats.CapacityUnits += capacity
+			stats.Failed += len(failed)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,315 @@
+package restore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Algorithms the S3 encryption clients wrap data keys and encrypt content with
+const (
+	WrapKMS        = "kms"
+	WrapKMSContext = "kms+context"
+	WrapAESGCM     = "AES/GCM"
+	WrapAESWrap    = "AESWrap"
+	CEKAESGCM      = "AES/GCM/NoPadding"
+	CEKAESCBC      = "AES/CBC/PKCS5Padding"
+)
+
+// Object metadata headers the S3 encryption clients write
+const (
+	metaKeyV1     = "X-Amz-Meta-X-Amz-Key"
+	metaKeyV2     = "X-Amz-Meta-X-Amz-Key-V2"
+	metaIV        = "X-Amz-Meta-X-Amz-Iv"
+	metaMatDesc   = "X-Amz-Meta-X-Amz-Matdesc"
+	metaWrapAlg   = "X-Amz-Meta-X-Amz-Wrap-Alg"
+	metaCEKAlg    = "X-Amz-Meta-X-Amz-Cek-Alg"
+	metaTagLen    = "X-Amz-Meta-X-Amz-Tag-Len"
+	cekAlgContext = "aws:x-amz-cek-alg"
+	gcmNonceSize  = 12
+)
+
+// Envelope is the metadata an S3 encryption client stores alongside an object
+type Envelope struct {
+	WrapAlgorithm string
+	CEKAlgorithm  string
+	// EncryptedKey is the object's data key, wrapped with a master key
+	EncryptedKey        []byte
+	IV                  []byte
+	MaterialDescription map[string]string
+	// TagLength is the GCM tag length in bits
+	TagLength int
+}
+
+// KeyProvider unwraps the data key an object's content is encrypted with
+type KeyProvider interface {
+	DecryptKey(env *Envelope) ([]byte, error)
+}
+
+// Decryption reads backup objects that were encrypted before or on upload. It's
+// applied to the raw object, before it's decompressed
+type Decryption struct {
+	// SSECustomerKey, if set, is sent with every read so S3 decrypts objects stored with SSE-C
+	SSECustomerKey []byte
+	// Keys, if set, decrypts objects written by an S3 encryption client in the envelope format.
+	// Objects without envelope metadata are read as they are
+	Keys KeyProvider
+}
+
+// headers are sent with every read of a backup object
+func (d *Decryption) headers() http.Header {
+	h := http.Header{}
+	if len(d.SSECustomerKey) > 0 {
+		sum := md5.Sum(d.SSECustomerKey)
+		h.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+		h.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(d.SSECustomerKey))
+		h.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return h
+}
+
+// decrypt reads an object's body back to what was uploaded, given its response headers
+func (d *Decryption) decrypt(header http.Header, body io.ReadCloser) (io.ReadCloser, error) {
+	if d.Keys == nil {
+		return body, nil
+	}
+	env, err := parseEnvelope(header)
+	if env == nil || err != nil {
+		return body, err
+	}
+	defer body.Close()
+	key, err := d.Keys.DecryptKey(env)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to decrypt data key: %v", err)
+	}
+	ciphertext, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptContent(env, key, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+// parseEnvelope reads an object's envelope metadata, returning nil if it isn't encrypted client side
+func parseEnvelope(header http.Header) (*Envelope, error) {
+	encoded := header.Get(metaKeyV2)
+	if encoded == "" {
+		if header.Get(metaKeyV1) != "" {
+			return nil, errors.New("Error: Objects encrypted by version 1 clients without a wrap algorithm aren't supported")
+		}
+		return nil, nil
+	}
+	env := &Envelope{
+		WrapAlgorithm:       header.Get(metaWrapAlg),
+		CEKAlgorithm:        header.Get(metaCEKAlg),
+		MaterialDescription: map[string]string{},
+		TagLength:           128,
+	}
+	var err error
+	if env.EncryptedKey, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+		return nil, fmt.Errorf("Error: Bad encrypted key in object metadata: %v", err)
+	}
+	if env.IV, err = base64.StdEncoding.DecodeString(header.Get(metaIV)); err != nil {
+		return nil, fmt.Errorf("Error: Bad IV in object metadata: %v", err)
+	}
+	if matDesc := header.Get(metaMatDesc); matDesc != "" {
+		if err := json.Unmarshal([]byte(matDesc), &env.MaterialDescription); err != nil {
+			return nil, fmt.Errorf("Error: Bad material description in object metadata: %v", err)
+		}
+	}
+	if tagLen := header.Get(metaTagLen); tagLen != "" {
+		if env.TagLength, err = strconv.Atoi(tagLen); err != nil {
+			return nil, fmt.Errorf("Error: Bad tag length in object metadata: %v", err)
+		}
+	}
+	return env, nil
+}
+
+// decryptContent decrypts an object's content with its data key
+func decryptContent(env *Envelope, key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	switch env.CEKAlgorithm {
+	case CEKAESGCM:
+		if env.TagLength != 128 {
+			return nil, fmt.Errorf("Error: Unsupported GCM tag length %d", env.TagLength)
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(env.IV))
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := gcm.Open(nil, env.IV, ciphertext, nil)
+		if err != nil {
+			return nil, errors.New("Error: Unable to decrypt object, it was altered or the key is wrong")
+		}
+		return plaintext, nil
+	case CEKAESCBC:
+		if len(env.IV) != aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 || len(ciphertext) == 0 {
+			return nil, errors.New("Error: Bad CBC encrypted object")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, env.IV).CryptBlocks(plaintext, ciphertext)
+		pad := int(plaintext[len(plaintext)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, errors.New("Error: Unable to decrypt object, the key is wrong")
+		}
+		// Every padding byte holds the padding length, which a wrong key almost never produces
+		for _, b := range plaintext[len(plaintext)-pad:] {
+			if int(b) != pad {
+				return nil, errors.New("Error: Unable to decrypt object, the key is wrong")
+			}
+		}
+		return plaintext[:len(plaintext)-pad], nil
+	}
+	return nil, errors.New("Error: Unsupported content encryption " + env.CEKAlgorithm)
+}
+
+// KMSKeys unwraps data keys with KMS
+type KMSKeys struct {
+	Client *kms.KMS
+}
+
+// NewKMSKeys unwraps data keys with KMS, called as the S3 credentials since the keys usually live with the backups
+func (a *AWS) NewKMSKeys() (*KMSKeys, error) {
+	sess, err := awsSession(a.Config, a.Config.S3Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &KMSKeys{Client: kms.New(sess)}, nil
+}
+
+// DecryptKey decrypts a data key with the material description as its encryption context
+func (k *KMSKeys) DecryptKey(env *Envelope) ([]byte, error) {
+	if env.WrapAlgorithm != WrapKMS && env.WrapAlgorithm != WrapKMSContext {
+		return nil, errors.New("Error: KMS can't unwrap keys wrapped with " + env.WrapAlgorithm)
+	}
+	if env.WrapAlgorithm == WrapKMSContext && env.MaterialDescription[cekAlgContext] != env.CEKAlgorithm {
+		return nil, errors.New("Error: Content encryption doesn't match the key's encryption context")
+	}
+	res, err := k.Client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    env.EncryptedKey,
+		EncryptionContext: aws.StringMap(env.MaterialDescription),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+// LocalKey unwraps data keys with an AES master key held locally, for tests and keys kept outside KMS
+type LocalKey struct {
+	Key []byte
+}
+
+// DecryptKey unwraps a data key wrapped with AES/GCM or AESWrap
+func (k *LocalKey) DecryptKey(env *Envelope) ([]byte, error) {
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	switch env.WrapAlgorithm {
+	case WrapAESGCM:
+		if len(env.EncryptedKey) < gcmNonceSize {
+			return nil, errors.New("Error: Wrapped key is too short")
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		// The content algorithm is authenticated along with the key
+		key, err := gcm.Open(nil, env.EncryptedKey[:gcmNonceSize], env.EncryptedKey[gcmNonceSize:], []byte(env.CEKAlgorithm))
+		if err != nil {
+			return nil, errors.New("Error: Unable to unwrap data key, the master key is wrong")
+		}
+		return key, nil
+	case WrapAESWrap:
+		return aesUnwrap(block, env.EncryptedKey)
+	}
+	return nil, errors.New("Error: A local key can't unwrap keys wrapped with " + env.WrapAlgorithm)
+}
+
+// Encrypt encrypts content the way an S3 encryption client does, with a fresh
+// data key wrapped by the master key. It returns the body to upload and the
+// metadata to store with it
+func (k *LocalKey) Encrypt(plaintext []byte) ([]byte, http.Header, error) {
+	master, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, 32)
+	keyNonce := make([]byte, gcmNonceSize)
+	iv := make([]byte, gcmNonceSize)
+	for _, b := range [][]byte{dataKey, keyNonce, iv} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	wrap, err := cipher.NewGCM(master)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped := wrap.Seal(append([]byte{}, keyNonce...), keyNonce, dataKey, []byte(CEKAESGCM))
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := http.Header{}
+	h.Set(metaKeyV2, base64.StdEncoding.EncodeToString(wrapped))
+	h.Set(metaIV, base64.StdEncoding.EncodeToString(iv))
+	h.Set(metaMatDesc, "{}")
+	h.Set(metaWrapAlg, WrapAESGCM)
+	h.Set(metaCEKAlg, CEKAESGCM)
+	h.Set(metaTagLen, "128")
+	return gcm.Seal(nil, iv, plaintext, nil), h, nil
+}
+
+// aesUnwrap unwraps a key wrapped with the RFC 3394 AES key wrap
+func aesUnwrap(block cipher.Block, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("Error: Wrapped key has a bad length")
+	}
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(a)^t)
+			copy(buf[8:], r[(i-1)*8:i*8])
+			block.Decrypt(buf, buf)
+			copy(a, buf[:8])
+			copy(r[(i-1)*8:i*8], buf[8:])
+		}
+	}
+	defaultIV := []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	if subtle.ConstantTimeCompare(a, defaultIV) != 1 {
+		return nil, errors.New("Error: Unable to unwrap data key, the master key is wrong")
+	}
+	return r, nil
+}
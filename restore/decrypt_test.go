@@ -0,0 +1,202 @@
+package restore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gaws "github.com/goamz/goamz/aws"
+	"github.com/goamz/goamz/s3"
+)
+
+const testBackup = `{"EventName":"INSERT","SequenceNumber":"1"}` + "\n"
+
+// testBucket serves one object with the given metadata headers, the way S3 would
+func testBucket(t *testing.T, key string, header http.Header, body []byte) *s3.Bucket {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/"+key {
+			http.NotFound(w, r)
+			return
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	auth := gaws.Auth{AccessKey: "access", SecretKey: "secret"}
+	return s3.New(auth, gaws.Region{Name: "test", S3Endpoint: srv.URL}).Bucket("bucket")
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOpenObjectDecryptsEnvelope(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(testBackup))
+	gz.Close()
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"plain", []byte(testBackup)},
+		{"gzipped", gzipped.Bytes()},
+	}
+	master := &LocalKey{Key: bytes.Repeat([]byte{7}, 32)}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, header, err := master.Encrypt(tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket := testBucket(t, "table/object", header, body)
+			r, err := openObject(bucket, "table/object", &Decryption{Keys: master})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != testBackup {
+				t.Errorf("got %q, want %q", got, testBackup)
+			}
+		})
+	}
+}
+
+func TestOpenObjectWithoutEnvelope(t *testing.T) {
+	bucket := testBucket(t, "table/object", nil, []byte(testBackup))
+	r, err := openObject(bucket, "table/object", &Decryption{Keys: &LocalKey{Key: make([]byte, 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, _ := io.ReadAll(r)
+	if string(got) != testBackup {
+		t.Errorf("got %q, want %q", got, testBackup)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	body, header, err := (&LocalKey{Key: bytes.Repeat([]byte{1}, 32)}).Encrypt([]byte(testBackup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := &Decryption{Keys: &LocalKey{Key: bytes.Repeat([]byte{2}, 32)}}
+	if _, err := dec.decrypt(header, io.NopCloser(bytes.NewReader(body))); err == nil {
+		t.Fatal("expected an error decrypting with the wrong master key")
+	}
+}
+
+// Test vectors from RFC 3394 section 4
+func TestAESUnwrap(t *testing.T) {
+	tests := []struct {
+		name, kek, key, wrapped string
+	}{
+		{"128 bit key with 128 bit KEK",
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447 AEF34BD8FB5A7B82 9D3E862371D2CFE5"},
+		{"128 bit key with 192 bit KEK",
+			"000102030405060708090A0B0C0D0E0F1011121314151617",
+			"00112233445566778899AABBCCDDEEFF",
+			"96778B25AE6CA435 F92B5B97C050AED2 468AB8A17AD84E5D"},
+		{"128 bit key with 256 bit KEK",
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF",
+			"64E8C3F9CE0F5BA2 63E9777905818A2A 93C8191E7D6E8AE7"},
+		{"192 bit key with 192 bit KEK",
+			"000102030405060708090A0B0C0D0E0F1011121314151617",
+			"00112233445566778899AABBCCDDEEFF0001020304050607",
+			"031D33264E15D332 68F24EC260743EDC E1C6C7DDEE725A93 6BA814915C6762D2"},
+		{"192 bit key with 256 bit KEK",
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF0001020304050607",
+			"A8F9BC1612C68B3F F6E6F4FBE30E71E4 769C8B80A32CB895 8CD5D17D6B254DA1"},
+		{"256 bit key with 256 bit KEK",
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4 CBCCB35CFB87F826 3F5786E2D80ED326 CBC7F0E71A99F43B FB988B9B7A02DD21"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &LocalKey{Key: mustHex(t, tt.kek)}
+			got, err := key.DecryptKey(&Envelope{WrapAlgorithm: WrapAESWrap, EncryptedKey: mustHex(t, tt.wrapped)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := mustHex(t, tt.key); !bytes.Equal(got, want) {
+				t.Errorf("got %x, want %x", got, want)
+			}
+		})
+	}
+	t.Run("wrong KEK", func(t *testing.T) {
+		key := &LocalKey{Key: make([]byte, 16)}
+		wrapped := mustHex(t, "1FA68B0A8112B447 AEF34BD8FB5A7B82 9D3E862371D2CFE5")
+		if _, err := key.DecryptKey(&Envelope{WrapAlgorithm: WrapAESWrap, EncryptedKey: wrapped}); err == nil {
+			t.Fatal("expected an error unwrapping with the wrong KEK")
+		}
+	})
+}
+
+// encryptCBC encrypts already padded plaintext
+func encryptCBC(t *testing.T, key, iv, padded []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return ciphertext
+}
+
+func TestDecryptContentCBC(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	iv := bytes.Repeat([]byte{4}, aes.BlockSize)
+	env := &Envelope{CEKAlgorithm: CEKAESCBC, IV: iv}
+	tests := []struct {
+		name    string
+		padded  string
+		want    string
+		wantErr bool
+	}{
+		{"short padding", "fifteen bytes!!\x01", "fifteen bytes!!", false},
+		{"long padding", "ten bytes!\x06\x06\x06\x06\x06\x06", "ten bytes!", false},
+		{"full block of padding", "sixteen bytes!!!" + strings.Repeat("\x10", 16), "sixteen bytes!!!", false},
+		{"zero padding", "fifteen bytes!!\x00", "", true},
+		{"padding longer than a block", "fifteen bytes!!\x11", "", true},
+		{"inconsistent padding", "ten bytes!\x06\x06\x06\x05\x06\x06", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptContent(env, key, encryptCBC(t, key, iv, []byte(tt.padded)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
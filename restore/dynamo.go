@@ -1,25 +1,23 @@
 package restore
 
 import (
-	"fmt"
+	"context"
 	"sort"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 const (
 	BatchWriteItemSizeLimit = 25
+	// maxBatchRetries is how many times unprocessed items are sent again before they count as failed
+	maxBatchRetries   = 5
+	batchRetryBackoff = 100 * time.Millisecond
+	// maxBatchRetryBackoff is the longest wait between retries, which the batch writer reaches on its last retry
+	maxBatchRetryBackoff = batchRetryBackoff << (maxBatchRetries - 1)
 )
 
-func dynamo(cfg *AWSConfig) *dynamodb.DynamoDB {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: &cfg.Region.Name,
-	}))
-	return dynamodb.New(sess)
-}
-
 // GetTable gets Dynamo Table
 func (a *AWS) getTable(name string) (*dynamodb.TableDescription, error) {
 	res, err := a.Dynamo.DescribeTable(&dynamodb.DescribeTableInput{TableName: &name})
@@ -29,36 +27,124 @@ func (a *AWS) getTable(name string) (*dynamodb.TableDescription, error) {
 	return res.Table, nil
 }
 
+// KeyNames lists the key attributes of a table
+func (a *AWS) KeyNames(table string) ([]string, error) {
+	td, err := a.getTable(table)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range td.KeySchema {
+		names = append(names, aws.StringValue(key.AttributeName))
+	}
+	return names, nil
+}
+
 // BatchWrite to Dynamo
-func (a *AWS) BatchWrite(targetTable string, recs StreamRecordWrappers) error {
+func (a *AWS) BatchWrite(targetTable string, recs StreamRecordWrappers) (*WriteStats, error) {
+	return a.BatchWriteWithContext(context.Background(), targetTable, recs)
+}
+
+// BatchWriteWithContext is BatchWrite, stopping between batches once ctx is done
+func (a *AWS) BatchWriteWithContext(ctx context.Context, targetTable string, recs StreamRecordWrappers) (*WriteStats, error) {
 	_, err := a.getTable(targetTable)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var wrs WriteRequests
 	sort.Sort(recs)
-	fmt.Println("Checking and removing any dupes...")
+	a.logger().Debug("Removing duplicate records", "table", targetTable)
+	stats := &WriteStats{Superseded: len(recs)}
 	recs.RemoveDupes()
+	stats.Superseded -= len(recs)
+	ProgressFromContext(ctx).Compacted(len(recs))
 	for _, rec := range recs {
 		wr := rec.CreateWriteRequest()
 		wrs = append(wrs, wr)
 	}
 	for i := 0; i < len(wrs); i += BatchWriteItemSizeLimit {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
 		var writeItem WriteRequests
 		if i+BatchWriteItemSizeLimit > len(wrs) {
 			writeItem = wrs[i:]
 		} else {
 			writeItem = wrs[i : i+BatchWriteItemSizeLimit]
 		}
-		req := map[string][]*dynamodb.WriteRequest{targetTable: writeItem}
-		res, err := a.Dynamo.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: req})
+		written, capacity, failed := a.writeBatch(ctx, targetTable, writeItem)
+		stats.Written += written
+		stats.CapacityUnits += capacity
+		stats.Failed += len(failed)
+		for _, wr := range failed {
+			stats.addFailedKey(requestKey(wr, recs[0].Keys))
+		}
+	}
+	return stats, nil
+}
+
+// writeBatch writes one batch, sending unprocessed items again with exponential
+// backoff. It returns the requests it gave up on
+func (a *AWS) writeBatch(ctx context.Context, targetTable string, batch WriteRequests) (written int, capacity float64, failed WriteRequests) {
+	progress := ProgressFromContext(ctx)
+	pending := batch
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > maxBatchRetries {
+				break
+			}
+			a.Metrics.retried(targetTable, len(pending))
+			select {
+			case <-time.After(batchRetryBackoff << uint(attempt-1)):
+			case <-ctx.Done():
+				a.deadLetterWrites(targetTable, pending, ctx.Err().Error(), attempt)
+				return written, capacity, pending
+			}
+		}
+		start := time.Now()
+		res, err := a.Dynamo.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems:           map[string][]*dynamodb.WriteRequest{targetTable: pending},
+			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+		})
 		if err != nil {
-			fmt.Println("Error posting batch request...", err)
-			continue
+			a.logger().Warn("Error posting batch request", "table", targetTable, "items", len(pending), "error", err)
+			a.Metrics.writeError(targetTable, err)
+			a.Metrics.batchWritten(targetTable, time.Since(start), 0, len(pending), 0)
+			a.deadLetterWrites(targetTable, pending, err.Error(), attempt+1)
+			return written, capacity, pending
 		}
-		fmt.Println("Batch write successful: ", res)
+		unprocessed := res.UnprocessedItems[targetTable]
+		if len(unprocessed) > 0 {
+			// DynamoDB hands back unprocessed items rather than failing the call when it throttles part of a batch
+			a.Metrics.throttled(targetTable)
+		}
+		done := len(pending) - len(unprocessed)
+		units := consumedCapacity(res.ConsumedCapacity...)
+		written += done
+		capacity += units
+		progress.Wrote(done, units)
+		a.Metrics.batchWritten(targetTable, time.Since(start), done, 0, units)
+		a.logger().Debug("Batch written", "table", targetTable, "items", len(pending), "unprocessed", len(unprocessed), "attempt", attempt+1)
+		pending = unprocessed
+	}
+	if len(pending) > 0 {
+		a.logger().Warn("Giving up on unprocessed items", "table", targetTable, "items", len(pending))
+		a.Metrics.itemsFailedToWrite(targetTable, len(pending))
+		a.deadLetterWrites(targetTable, pending, "still unprocessed after retries", maxBatchRetries+1)
+	}
+	return written, capacity, pending
+}
+
+// requestKey picks the key attributes, named like those in keys, out of a write request
+func requestKey(wr *dynamodb.WriteRequest, keys map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if wr.DeleteRequest != nil {
+		return wr.DeleteRequest.Key
 	}
-	return nil
+	key := map[string]*dynamodb.AttributeValue{}
+	for name := range keys {
+		key[name] = wr.PutRequest.Item[name]
+	}
+	return key
 }
 
 // CreateTableFrom clones a table's attributes
@@ -124,3 +210,14 @@ func createProvisionedThroughputFromDescription(ptd *dynamodb.ProvisionedThrough
 		WriteCapacityUnits: ptd.WriteCapacityUnits,
 	}
 }
+
+// consumedCapacity totals the capacity units a request reports
+func consumedCapacity(ccs ...*dynamodb.ConsumedCapacity) float64 {
+	var units float64
+	for _, cc := range ccs {
+		if cc != nil {
+			units += aws.Float64Value(cc.CapacityUnits)
+		}
+	}
+	return units
+}
@@ -1,9 +1,17 @@
 package restore
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 const (
@@ -38,3 +46,58 @@ func (d DynamoType) UnmarshalJSON(b []byte) error {
 	}
 	return errors.New(fmt.Sprintf("Error: unable to marshal %v into DynamoType", b))
 }
+
+// MarshalAttributeValues encodes attribute values as compact DynamoDB JSON, leaving out unset types
+func MarshalAttributeValues(v interface{}) (json.RawMessage, error) {
+	return jsonutil.BuildJSON(v)
+}
+
+// FormatAttributeValue renders an attribute value for people to read
+func FormatAttributeValue(av *dynamodb.AttributeValue) string {
+	switch {
+	case av == nil:
+		return "-"
+	case av.S != nil:
+		return strconv.Quote(*av.S)
+	case av.N != nil:
+		return *av.N
+	case av.BOOL != nil:
+		return strconv.FormatBool(*av.BOOL)
+	case av.NULL != nil:
+		return "null"
+	case av.B != nil:
+		return base64.StdEncoding.EncodeToString(av.B)
+	case av.SS != nil:
+		var ss []string
+		for _, s := range av.SS {
+			ss = append(ss, strconv.Quote(aws.StringValue(s)))
+		}
+		return "<<" + strings.Join(ss, ", ") + ">>"
+	case av.NS != nil:
+		return "<<" + strings.Join(aws.StringValueSlice(av.NS), ", ") + ">>"
+	case av.BS != nil:
+		var bs []string
+		for _, b := range av.BS {
+			bs = append(bs, base64.StdEncoding.EncodeToString(b))
+		}
+		return "<<" + strings.Join(bs, ", ") + ">>"
+	case av.L != nil:
+		var l []string
+		for _, v := range av.L {
+			l = append(l, FormatAttributeValue(v))
+		}
+		return "[" + strings.Join(l, ", ") + "]"
+	case av.M != nil:
+		var names []string
+		for name := range av.M {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var m []string
+		for _, name := range names {
+			m = append(m, name+": "+FormatAttributeValue(av.M[name]))
+		}
+		return "{" + strings.Join(m, ", ") + "}"
+	}
+	return "-"
+}
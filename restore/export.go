@@ -0,0 +1,244 @@
+package restore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// Export formats written by DynamoDB's export to S3
+const (
+	ExportFormatJSON = "DYNAMODB_JSON"
+	ExportFormatIon  = "ION"
+)
+
+const (
+	exportSummaryFile = "manifest-summary.json"
+	// exportSequenceNumber sorts export items before any stream record
+	exportSequenceNumber = "0"
+)
+
+// ExportSummary is an export's manifest-summary.json
+type ExportSummary struct {
+	Version            string    `json:"version"`
+	ExportArn          string    `json:"exportArn"`
+	StartTime          time.Time `json:"startTime"`
+	EndTime            time.Time `json:"endTime"`
+	TableArn           string    `json:"tableArn"`
+	ExportTime         time.Time `json:"exportTime"`
+	S3Bucket           string    `json:"s3Bucket"`
+	S3Prefix           string    `json:"s3Prefix"`
+	ManifestFilesS3Key string    `json:"manifestFilesS3Key"`
+	ItemCount          int64     `json:"itemCount"`
+	OutputFormat       string    `json:"outputFormat"`
+}
+
+// TableName is the exported table's name, taken from its ARN
+func (s *ExportSummary) TableName() string {
+	return s.TableArn[strings.LastIndex(s.TableArn, "/")+1:]
+}
+
+// exportDataFile is one line of an export's manifest-files.json
+type exportDataFile struct {
+	ItemCount     int64  `json:"itemCount"`
+	MD5Checksum   string `json:"md5Checksum"`
+	ETag          string `json:"etag"`
+	DataFileS3Key string `json:"dataFileS3Key"`
+}
+
+// ExportSource reads a table's items from a DynamoDB export to S3. Every item
+// becomes an INSERT made at the export time, so it restores like any backup
+type ExportSource struct {
+	a       *AWS
+	bucket  string
+	keys    []string
+	Summary *ExportSummary
+}
+
+// NewExportSource reads the manifest of the export at s3://bucket/prefix/AWSDynamoDB/<exportId>/.
+// keys names the table's key attributes, which exported items don't mark
+func (a *AWS) NewExportSource(location string, keys []string) (*ExportSource, error) {
+	bucketName, prefix, ok := ParseS3URL(location)
+	if !ok {
+		return nil, errors.New("Error: Export location must be formatted as s3://bucket/prefix/AWSDynamoDB/exportId/")
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("Error: Export source needs the table's key attributes")
+	}
+	bucket, err := a.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := bucket.Get(strings.TrimSuffix(prefix, "/") + "/" + exportSummaryFile)
+	if err != nil {
+		return nil, err
+	}
+	summary := &ExportSummary{}
+	if err := json.Unmarshal(raw, summary); err != nil {
+		return nil, fmt.Errorf("Error: Unable to read %s: %v", exportSummaryFile, err)
+	}
+	switch summary.OutputFormat {
+	case ExportFormatJSON, ExportFormatIon:
+	default:
+		return nil, errors.New("Error: Unknown export format " + summary.OutputFormat)
+	}
+	return &ExportSource{a: a, bucket: bucketName, keys: keys, Summary: summary}, nil
+}
+
+// Objects lists the export's data files. An export is a single table at a
+// single point in time, so table is ignored and the window only drops items
+// when it ends before the export time
+func (e *ExportSource) Objects(ctx context.Context, table string, w Window) ([]Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bucket, err := e.a.bucket(e.bucket)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := bucket.Get(e.Summary.ManifestFilesS3Key)
+	if err != nil {
+		return nil, err
+	}
+	// The manifest doesn't give sizes, so they come from listing the data files next to it
+	dir := e.Summary.ManifestFilesS3Key[:strings.LastIndex(e.Summary.ManifestFilesS3Key, "/")+1]
+	listed, err := listKeys(bucket, dir+"data/", "")
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int64{}
+	for _, key := range listed {
+		sizes[key.Key] = key.Size
+	}
+	var objs []Object
+	scanner := bufio.NewScanner(strings.NewReader(string(raw)))
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var file exportDataFile
+		if err := json.Unmarshal(scanner.Bytes(), &file); err != nil {
+			return nil, fmt.Errorf("Error: Unable to read export manifest: %v", err)
+		}
+		size, ok := sizes[file.DataFileS3Key]
+		if !ok {
+			return nil, errors.New("Error: Export data file " + file.DataFileS3Key + " is missing")
+		}
+		objs = append(objs, Object{Key: file.DataFileS3Key, Size: size, ETag: file.ETag})
+	}
+	return objs, scanner.Err()
+}
+
+// Records decodes the items in one data file
+func (e *ExportSource) Records(ctx context.Context, obj Object) (StreamRecordWrappers, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bucket, err := e.a.bucket(e.bucket)
+	if err != nil {
+		return nil, err
+	}
+	// Exports are only ever encrypted server side
+	r, err := openObject(bucket, obj.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	items, err := e.decode(r, obj.Key)
+	if err != nil {
+		return nil, err
+	}
+	recs := make(StreamRecordWrappers, len(items))
+	for i, item := range items {
+		recs[i] = e.record(item)
+	}
+	e.a.Metrics.objectFetched(e.Summary.TableName(), obj.Size)
+	return recs, nil
+}
+
+func (e *ExportSource) decode(r io.Reader, key string) ([]map[string]*dynamodb.AttributeValue, error) {
+	table := e.Summary.TableName()
+	if e.Summary.OutputFormat == ExportFormatIon {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		items, err := parseIonItems(string(data))
+		if err != nil {
+			e.a.Metrics.decodeError(table)
+			if e.a.DeadLetters == nil {
+				return nil, err
+			}
+			// Without a readable structure there's no telling where items start, so the whole object is dead-lettered
+			e.a.logger().Warn("Error unmarshalling export object, skipping it", "key", key, "error", err)
+			e.a.deadLetter(&DeadLetter{Kind: DeadLetterDecode, Format: ExportFormatIon, Table: table, Object: key, Error: err.Error(), Attempts: 1})
+			return nil, &SkippedObjectError{Key: key, Err: err}
+		}
+		return items, nil
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		entry, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(entry))) > 0 {
+			if item, uerr := decodeExportLine(entry); uerr != nil {
+				e.a.logger().Warn("Error unmarshalling export item", "key", key, "line", line, "error", uerr)
+				e.a.Metrics.decodeError(table)
+				e.a.deadLetter(&DeadLetter{Kind: DeadLetterDecode, Format: ExportFormatJSON, Table: table, Object: key, Line: line,
+					Raw: strings.TrimRight(string(entry), "\r\n"), Error: uerr.Error(), Attempts: 1})
+			} else {
+				items = append(items, item)
+			}
+		}
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return items, err
+		}
+	}
+}
+
+// decodeExportLine reads the item from one line of a DynamoDB JSON export
+func decodeExportLine(line []byte) (map[string]*dynamodb.AttributeValue, error) {
+	var wrapped struct {
+		Item map[string]*dynamodb.AttributeValue `json:"Item"`
+	}
+	if err := json.Unmarshal(line, &wrapped); err != nil {
+		return nil, err
+	}
+	if wrapped.Item == nil {
+		return nil, errors.New("no Item")
+	}
+	return wrapped.Item, nil
+}
+
+// record turns an exported item into an INSERT made at the export time
+func (e *ExportSource) record(item map[string]*dynamodb.AttributeValue) *StreamRecordWrapper {
+	return insertRecord(item, e.keys, e.Summary.ExportTime, exportSequenceNumber)
+}
+
+// insertRecord turns a whole item into an INSERT made at a point in time
+func insertRecord(item map[string]*dynamodb.AttributeValue, keyNames []string, at time.Time, seq string) *StreamRecordWrapper {
+	keys := map[string]*dynamodb.AttributeValue{}
+	for _, name := range keyNames {
+		keys[name] = item[name]
+	}
+	return &StreamRecordWrapper{
+		ApproximateCreationDateTime: &at,
+		Keys:                        keys,
+		NewImage:                    item,
+		SequenceNumber:              aws.String(seq),
+		StreamViewType:              aws.String(dynamodbstreams.StreamViewTypeNewImage),
+		EventName:                   dynamodbstreams.OperationTypeInsert,
+	}
+}
@@ -0,0 +1,552 @@
+package restore
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Filter is a condition records have to meet to be restored. It uses DynamoDB's
+// condition expression syntax, with literal values written inline:
+//
+//	eventName = "REMOVE"
+//	Keys.tenant = "acme" AND NOT attribute_exists(deletedAt)
+//	status IN ("active", "pending") AND timestamp >= "2026-10-01-12:00"
+//	begins_with(NewImage.sk, "order#") OR contains(OldImage.tags, "vip")
+//
+// Paths starting with Keys, NewImage or OldImage read that part of the record and
+// other attribute paths read the record's current image. eventName,
+// sequenceNumber and timestamp read the record itself; timestamp compares
+// against YYYY-MM-DD-HH:MM or RFC 3339 times
+type Filter struct {
+	expr string
+	cond condition
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Error: invalid filter: %v", err)
+	}
+	p := &filterParser{tokens: tokens}
+	cond, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error: invalid filter: %v", err)
+	}
+	return &Filter{expr: expr, cond: cond}, nil
+}
+
+// Match checks a record against the filter. A nil filter matches everything
+func (f *Filter) Match(rec *StreamRecordWrapper) bool {
+	if f == nil {
+		return true
+	}
+	return f.cond.eval(rec)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type condition interface {
+	eval(rec *StreamRecordWrapper) bool
+}
+
+type operand interface {
+	value(rec *StreamRecordWrapper) *dynamodb.AttributeValue
+}
+
+type andCondition struct{ left, right condition }
+type orCondition struct{ left, right condition }
+type notCondition struct{ cond condition }
+
+func (c andCondition) eval(rec *StreamRecordWrapper) bool {
+	return c.left.eval(rec) && c.right.eval(rec)
+}
+
+func (c orCondition) eval(rec *StreamRecordWrapper) bool {
+	return c.left.eval(rec) || c.right.eval(rec)
+}
+
+func (c notCondition) eval(rec *StreamRecordWrapper) bool {
+	return !c.cond.eval(rec)
+}
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (c comparison) eval(rec *StreamRecordWrapper) bool {
+	cmp, ok := compareAttributeValues(c.left.value(rec), c.right.value(rec))
+	if !ok {
+		// Missing attributes and mismatched types never compare, like in DynamoDB
+		return false
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+type betweenCondition struct{ subject, low, high operand }
+
+func (c betweenCondition) eval(rec *StreamRecordWrapper) bool {
+	v := c.subject.value(rec)
+	low, okLow := compareAttributeValues(v, c.low.value(rec))
+	high, okHigh := compareAttributeValues(v, c.high.value(rec))
+	return okLow && okHigh && low >= 0 && high <= 0
+}
+
+type inCondition struct {
+	subject operand
+	options []operand
+}
+
+func (c inCondition) eval(rec *StreamRecordWrapper) bool {
+	v := c.subject.value(rec)
+	for _, option := range c.options {
+		if cmp, ok := compareAttributeValues(v, option.value(rec)); ok && cmp == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type functionCondition struct {
+	name string
+	args []operand
+}
+
+func (c functionCondition) eval(rec *StreamRecordWrapper) bool {
+	subject := c.args[0].value(rec)
+	switch c.name {
+	case "attribute_exists":
+		return subject != nil
+	case "attribute_not_exists":
+		return subject == nil
+	case "begins_with":
+		prefix := c.args[1].value(rec)
+		return subject != nil && subject.S != nil && prefix != nil && prefix.S != nil && strings.HasPrefix(*subject.S, *prefix.S)
+	case "contains":
+		return containsValue(subject, c.args[1].value(rec))
+	}
+	return false
+}
+
+// containsValue checks for a substring of a string or an element of a set or list
+func containsValue(subject, v *dynamodb.AttributeValue) bool {
+	if subject == nil || v == nil {
+		return false
+	}
+	if subject.S != nil && v.S != nil {
+		return strings.Contains(*subject.S, *v.S)
+	}
+	var elems []*dynamodb.AttributeValue
+	for _, s := range subject.SS {
+		elems = append(elems, &dynamodb.AttributeValue{S: s})
+	}
+	for _, n := range subject.NS {
+		elems = append(elems, &dynamodb.AttributeValue{N: n})
+	}
+	elems = append(elems, subject.L...)
+	for _, elem := range elems {
+		if cmp, ok := compareAttributeValues(elem, v); ok && cmp == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compareAttributeValues orders strings and numbers and checks everything else for equality
+func compareAttributeValues(a, b *dynamodb.AttributeValue) (int, bool) {
+	switch {
+	case a == nil || b == nil:
+		return 0, false
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.N != nil && b.N != nil:
+		// Sequence numbers need more precision than a float64
+		x, okX := new(big.Float).SetPrec(numberPrecision).SetString(*a.N)
+		y, okY := new(big.Float).SetPrec(numberPrecision).SetString(*b.N)
+		if !okX || !okY {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case a.BOOL != nil && b.BOOL != nil:
+		if *a.BOOL == *b.BOOL {
+			return 0, true
+		}
+		return 1, true
+	case a.NULL != nil && b.NULL != nil:
+		return 0, true
+	}
+	return 0, false
+}
+
+// numberPrecision covers DynamoDB's 38 significant digits
+const numberPrecision = 128
+
+type literal struct{ av *dynamodb.AttributeValue }
+
+func (l literal) value(rec *StreamRecordWrapper) *dynamodb.AttributeValue {
+	return l.av
+}
+
+type path []string
+
+func (p path) value(rec *StreamRecordWrapper) *dynamodb.AttributeValue {
+	switch p[0] {
+	case "eventName":
+		return &dynamodb.AttributeValue{S: aws.String(rec.EventName)}
+	case "sequenceNumber":
+		return &dynamodb.AttributeValue{N: rec.SequenceNumber}
+	case "timestamp":
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(rec.ApproximateCreationDateTime.Unix(), 10))}
+	}
+	image, rest := rec.GetImage(), []string(p)
+	if len(p) > 1 {
+		switch p[0] {
+		case "Keys":
+			image, rest = rec.Keys, p[1:]
+		case "NewImage":
+			image, rest = rec.NewImage, p[1:]
+		case "OldImage":
+			image, rest = rec.OldImage, p[1:]
+		}
+	}
+	av := image[rest[0]]
+	for _, name := range rest[1:] {
+		if av == nil || av.M == nil {
+			return nil
+		}
+		av = av.M[name]
+	}
+	return av
+}
+
+type filterToken struct {
+	kind string
+	text string
+}
+
+const (
+	tokenIdent  = "identifier"
+	tokenString = "string"
+	tokenNumber = "number"
+	tokenSymbol = "symbol"
+)
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, filterToken{tokenString, sb.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenIdent, string(runes[i:j])})
+			i = j
+		case strings.HasPrefix(string(runes[i:]), "<>") || strings.HasPrefix(string(runes[i:]), "<=") || strings.HasPrefix(string(runes[i:]), ">="):
+			tokens = append(tokens, filterToken{tokenSymbol, string(runes[i : i+2])})
+			i += 2
+		case strings.ContainsRune("=<>(),.", r):
+			tokens = append(tokens, filterToken{tokenSymbol, string(r)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword checks for a case insensitive keyword, consuming it if it's there
+func (p *filterParser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenIdent && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) symbol(s string) bool {
+	t := p.peek()
+	if t.kind == tokenSymbol && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(s string) error {
+	if !p.symbol(s) {
+		if p.done() {
+			return fmt.Errorf("expected %q at the end", s)
+		}
+		return fmt.Errorf("expected %q but got %q", s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andCondition{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (condition, error) {
+	if p.keyword("NOT") {
+		cond, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{cond}, nil
+	}
+	return p.parsePrimary()
+}
+
+var filterFunctions = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+func (p *filterParser) parsePrimary() (condition, error) {
+	if p.symbol("(") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.expect(")")
+	}
+	if t := p.peek(); t.kind == tokenIdent {
+		if arity, ok := filterFunctions[t.text]; ok {
+			p.next()
+			return p.parseFunction(t.text, arity)
+		}
+	}
+	subject, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("BETWEEN") {
+		low, err := p.parseOperandFor(subject)
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, errors.New("expected AND in BETWEEN")
+		}
+		high, err := p.parseOperandFor(subject)
+		if err != nil {
+			return nil, err
+		}
+		return betweenCondition{subject, low, high}, nil
+	}
+	if p.keyword("IN") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var options []operand
+		for {
+			option, err := p.parseOperandFor(subject)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, option)
+			if !p.symbol(",") {
+				break
+			}
+		}
+		return inCondition{subject, options}, p.expect(")")
+	}
+	op := p.next()
+	switch op.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected a comparison but got %q", op.text)
+	}
+	right, err := p.parseOperandFor(subject)
+	if err != nil {
+		return nil, err
+	}
+	return comparison{op.text, subject, right}, nil
+}
+
+func (p *filterParser) parseFunction(name string, arity int) (condition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []operand
+	for i := 0; i < arity; i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if _, ok := args[0].(path); !ok {
+		return nil, errors.New(name + " needs an attribute path first")
+	}
+	return functionCondition{name, args}, p.expect(")")
+}
+
+// parseOperandFor parses an operand compared with subject, reading times when subject is the timestamp
+func (p *filterParser) parseOperandFor(subject operand) (operand, error) {
+	o, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if sp, ok := subject.(path); ok && len(sp) == 1 && sp[0] == "timestamp" {
+		if l, ok := o.(literal); ok && l.av.S != nil {
+			t, err := parseFilterTime(*l.av.S)
+			if err != nil {
+				return nil, err
+			}
+			return literal{&dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}}, nil
+		}
+	}
+	return o, nil
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02-15:04", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q must be formatted as YYYY-MM-DD-HH:MM or RFC 3339", s)
+}
+
+func (p *filterParser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return literal{&dynamodb.AttributeValue{S: aws.String(t.text)}}, nil
+	case tokenNumber:
+		if _, err := strconv.ParseFloat(t.text, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return literal{&dynamodb.AttributeValue{N: aws.String(t.text)}}, nil
+	case tokenIdent:
+		switch strings.ToLower(t.text) {
+		case "true", "false":
+			return literal{&dynamodb.AttributeValue{BOOL: aws.Bool(strings.ToLower(t.text) == "true")}}, nil
+		case "null":
+			return literal{&dynamodb.AttributeValue{NULL: aws.Bool(true)}}, nil
+		}
+		attr := path{t.text}
+		for p.symbol(".") {
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, errors.New("expected an attribute name after .")
+			}
+			attr = append(attr, name.text)
+		}
+		return attr, nil
+	case "":
+		return nil, errors.New("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
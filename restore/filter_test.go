@@ -0,0 +1,183 @@
+package restore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func filterTestRecord() *StreamRecordWrapper {
+	at := time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC)
+	return &StreamRecordWrapper{
+		ApproximateCreationDateTime: &at,
+		EventName:                   "MODIFY",
+		SequenceNumber:              aws.String("100000000000000000000000001"),
+		Keys: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String("acme#1")},
+		},
+		NewImage: map[string]*dynamodb.AttributeValue{
+			"pk":     {S: aws.String("acme#1")},
+			"status": {S: aws.String("active")},
+			"count":  {N: aws.String("10")},
+			"note":   {S: aws.String("hello world")},
+			"tags":   {SS: aws.StringSlice([]string{"vip", "new"})},
+			"scores": {NS: aws.StringSlice([]string{"1", "2.5"})},
+			"flag":   {BOOL: aws.Bool(true)},
+			"nested": {M: map[string]*dynamodb.AttributeValue{"level": {N: aws.String("3")}}},
+		},
+		OldImage: map[string]*dynamodb.AttributeValue{
+			"pk":     {S: aws.String("acme#1")},
+			"status": {S: aws.String("pending")},
+			"count":  {N: aws.String("9")},
+		},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Precedence: NOT binds tighter than AND, which binds tighter than OR
+		{`status = "active" OR status = "x" AND count = 0`, true},
+		{`(status = "active" OR status = "x") AND count = 0`, false},
+		{`status = "x" AND count = 0 OR count = 10`, true},
+		{`status = "x" AND (count = 0 OR count = 10)`, false},
+		{`NOT status = "x" AND count = 10`, true},
+		{`NOT (status = "active" AND count = 10)`, false},
+		{`status = "x" OR NOT count = 9`, true},
+		{`NOT NOT status = "active"`, true},
+		{`status = "active" and count = 10`, true},
+
+		// Functions
+		{`begins_with(pk, "acme#")`, true},
+		{`begins_with(pk, "other")`, false},
+		{`begins_with(count, "1")`, false},
+		{`begins_with(NewImage.missing, "a")`, false},
+		{`contains(note, "world")`, true},
+		{`contains(note, "planet")`, false},
+		{`contains(tags, "vip")`, true},
+		{`contains(tags, "old")`, false},
+		{`contains(scores, 2.50)`, true},
+		{`contains(OldImage.tags, "vip")`, false},
+		{`attribute_exists(nested.level)`, true},
+		{`attribute_exists(nested.missing)`, false},
+		{`attribute_exists(OldImage.note)`, false},
+		{`attribute_not_exists(missing)`, true},
+		{`attribute_not_exists(Keys.pk)`, false},
+
+		// Numbers
+		{`count > 9`, true},
+		{`count > 9.5`, true},
+		{`count = 10.0`, true},
+		{`count < 1e2`, true},
+		{`count >= 11`, false},
+		{`count <> 10`, false},
+		{`count > -1`, true},
+		{`OldImage.count = 9`, true},
+		{`count BETWEEN 5 AND 10`, true},
+		{`count BETWEEN 11 AND 20`, false},
+		{`nested.level IN (1, 2, 3)`, true},
+		{`nested.level IN (4, 5)`, false},
+		{`sequenceNumber > 100000000000000000000000000`, true},
+		{`sequenceNumber < 100000000000000000000000002`, true},
+
+		// Strings
+		{`status > "aaa"`, true},
+		{`status < "active"`, false},
+		{`status <= "active"`, true},
+		{`status IN ("pending", "active")`, true},
+		{`OldImage.status = 'pending'`, true},
+		{`Keys.pk = "acme#1"`, true},
+		{`eventName = "MODIFY"`, true},
+		{`eventName <> "REMOVE"`, true},
+		{`note = "hello \"world\""`, false},
+
+		// Mismatched types and missing attributes never compare
+		{`count = "10"`, false},
+		{`count <> "10"`, false},
+		{`missing <> "x"`, false},
+		{`flag = true`, true},
+		{`flag <> false`, true},
+
+		// Timestamps
+		{`timestamp >= "2026-10-01-12:00"`, true},
+		{`timestamp < "2026-10-01-12:30"`, false},
+		{`timestamp = "2026-10-01-12:30"`, true},
+		{`timestamp BETWEEN "2026-10-01T12:00:00Z" AND "2026-10-01T13:00:00Z"`, true},
+		{`timestamp IN ("2026-10-01-12:30")`, true},
+		{`timestamp > "2026-10-01T13:00:00+01:00"`, true},
+		{`timestamp > "2026-10-01T13:00:00Z"`, false},
+	}
+	rec := filterTestRecord()
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(rec); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterReadsOldImageOfRemoves(t *testing.T) {
+	rec := filterTestRecord()
+	rec.EventName = "REMOVE"
+	rec.NewImage = nil
+	f, err := ParseFilter(`status = "pending"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Match(rec) {
+		t.Error("expected a remove to be matched against its old image")
+	}
+}
+
+func TestNilFilterMatches(t *testing.T) {
+	var f *Filter
+	if !f.Match(filterTestRecord()) {
+		t.Error("expected a nil filter to match")
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		// want is part of the expected error
+		want string
+	}{
+		{``, "unexpected end of filter"},
+		{`status =`, "unexpected end of filter"},
+		{`status "active"`, "expected a comparison"},
+		{`status = "active" extra`, `unexpected "extra"`},
+		{`status = "active" AND`, "unexpected end of filter"},
+		{`(status = "active"`, `expected ")" at the end`},
+		{`status = "unterminated`, "unterminated string"},
+		{`status # 1`, `unexpected '#'`},
+		{`count = 1.2.3`, `invalid number "1.2.3"`},
+		{`nested. = 1`, "expected an attribute name after ."},
+		{`begins_with(pk)`, `expected ","`},
+		{`begins_with("a", pk)`, "begins_with needs an attribute path first"},
+		{`attribute_exists pk`, `expected "("`},
+		{`count BETWEEN 1 2`, "expected AND in BETWEEN"},
+		{`status IN ("a" "b")`, `expected ")"`},
+		{`timestamp > "yesterday"`, "must be formatted as YYYY-MM-DD-HH:MM or RFC 3339"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseFilter(tt.expr)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), "Error: invalid filter: ") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
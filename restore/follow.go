@@ -0,0 +1,151 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const defaultFollowInterval = time.Minute
+
+// followState is what a followed table's backups have been consumed up to
+type followState struct {
+	// marker is the key listing starts after. It trails the latest consumed
+	// object by deliverySlack, since objects can land in an hour that's already been listed
+	marker string
+	seen   map[string]time.Time
+}
+
+// pendingSource serves the objects a poll found to the restorer
+type pendingSource struct {
+	*AWS
+	pending map[string][]Object
+}
+
+func (s *pendingSource) Objects(ctx context.Context, table string, w Window) ([]Object, error) {
+	return s.pending[table], ctx.Err()
+}
+
+// Follow keeps a restore's targets up to date after it's run, polling the backup
+// prefix of every table for objects written since and applying them with the
+// restorer's sink, filter and processors. after is the report of the initial
+// restore, whose objects aren't applied again. Each poll's report is passed to
+// onPoll. A table that fails has its objects retried on the next poll. Follow
+// returns once ctx is done. A restorer with a consistent cut-off can't be
+// followed, since records after the cut-off were read but never applied
+func (r *Restorer) Follow(ctx context.Context, backups *AWS, after *Report, interval time.Duration, onPoll func(*Report)) error {
+	if r.consistent {
+		return errors.New("Error: a restore with a consistent cut-off can't be followed")
+	}
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+	states := map[string]*followState{}
+	for _, m := range r.tables {
+		state := &followState{seen: map[string]time.Time{}}
+		for _, table := range after.Tables {
+			if table.Source != m.Source {
+				continue
+			}
+			for _, obj := range table.ObjectsRead {
+				if hour, ok := partitionTime(backups.tablePrefix(m.Source), obj.Key); ok {
+					state.seen[obj.Key] = hour
+				}
+			}
+		}
+		// Anything listed from here on that wasn't read was written after the restore started
+		state.advance(backups.tablePrefix(m.Source), after.StartedAt.UTC().Truncate(time.Hour))
+		states[m.Source] = state
+	}
+	r.logger.Info("Following backups", "interval", interval)
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+		report := r.poll(ctx, backups, states)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if report != nil && onPoll != nil {
+			onPoll(report)
+		}
+	}
+}
+
+// poll applies every table's new objects, returning nil if there weren't any
+func (r *Restorer) poll(ctx context.Context, backups *AWS, states map[string]*followState) *Report {
+	source := &pendingSource{AWS: backups, pending: map[string][]Object{}}
+	var tables []TableMapping
+	for _, m := range r.tables {
+		state := states[m.Source]
+		prefix := backups.tablePrefix(m.Source)
+		keys, err := backups.ListObjectsAfter(prefix, state.marker)
+		if err != nil {
+			r.logger.Error("Error listing new backups", "source", m.Source, "marker", state.marker, "error", err)
+			continue
+		}
+		for _, key := range keys {
+			if _, ok := state.seen[key.Key]; ok {
+				continue
+			}
+			if _, ok := partitionTime(prefix, key.Key); ok {
+				source.pending[m.Source] = append(source.pending[m.Source], keyObject(key))
+			}
+		}
+		if len(source.pending[m.Source]) > 0 {
+			tables = append(tables, m)
+		}
+	}
+	if len(tables) == 0 {
+		r.logger.Debug("No new backups")
+		return nil
+	}
+	// Each poll is a restore of just the new objects, open ended so none of their records are dropped
+	p := *r
+	p.source = source
+	p.tables = tables
+	p.window = Window{Start: r.window.Start}
+	p.consistent = false
+	p.progress = nil
+	report, err := p.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		r.logger.Error("Error applying new backups, retrying them on the next poll", "error", err)
+	}
+	for _, table := range report.Tables {
+		if table.Error != "" {
+			continue
+		}
+		state := states[table.Source]
+		prefix := backups.tablePrefix(table.Source)
+		var latest time.Time
+		for _, obj := range table.ObjectsRead {
+			hour, _ := partitionTime(prefix, obj.Key)
+			state.seen[obj.Key] = hour
+			if hour.After(latest) {
+				latest = hour
+			}
+		}
+		state.advance(prefix, latest)
+		r.logger.Info("Applied new backups", "source", table.Source, "target", table.Target, "objects", table.Objects,
+			"records", table.Records, "lastEvent", table.LastEventTime, "marker", state.marker)
+	}
+	return report
+}
+
+// advance moves the marker up to trail the latest hour consumed, forgetting objects listing no longer reaches
+func (s *followState) advance(prefix string, latest time.Time) {
+	for _, hour := range s.seen {
+		if hour.After(latest) {
+			latest = hour
+		}
+	}
+	from := latest.Add(-deliverySlack)
+	for key, hour := range s.seen {
+		if hour.Before(from) {
+			delete(s.seen, key)
+		}
+	}
+	s.marker = prefix + from.Format(partitionLayout)
+}
@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// KeyCondition matches a key attribute against a string or number value
+type KeyCondition struct {
+	Name  string
+	Value string
+}
+
+// HistoryEvent is one change made to an item
+type HistoryEvent struct {
+	Time           time.Time         `json:"time"`
+	SequenceNumber string            `json:"sequenceNumber"`
+	EventName      string            `json:"eventName"`
+	Changes        []AttributeChange `json:"changes"`
+}
+
+// AttributeChange is an attribute before and after a change. Old or New is nil when the attribute was added or removed
+type AttributeChange struct {
+	Name string
+	Old  *dynamodb.AttributeValue
+	New  *dynamodb.AttributeValue
+}
+
+// MarshalJSON writes the old and new values as DynamoDB JSON
+func (c AttributeChange) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		Name string          `json:"name"`
+		Old  json.RawMessage `json:"old,omitempty"`
+		New  json.RawMessage `json:"new,omitempty"`
+	}{Name: c.Name}
+	var err error
+	if c.Old != nil {
+		if aux.Old, err = MarshalAttributeValues(c.Old); err != nil {
+			return nil, err
+		}
+	}
+	if c.New != nil {
+		if aux.New, err = MarshalAttributeValues(c.New); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(aux)
+}
+
+// History collects every change made to one item during the window, oldest first
+func (a *AWS) History(table string, w Window, key []KeyCondition) ([]*HistoryEvent, error) {
+	keys, err := a.ListPartitions(table, w)
+	if err != nil {
+		return nil, err
+	}
+	var matched StreamRecordWrappers
+	// Objects are read one at a time so only the matching records are kept around
+	for _, k := range keys {
+		recs, err := a.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recs {
+			if w.Contains(*rec.ApproximateCreationDateTime) && rec.MatchesKey(key) {
+				matched = append(matched, rec)
+			}
+		}
+	}
+	sort.Sort(matched)
+	var events []*HistoryEvent
+	for _, rec := range matched {
+		events = append(events, &HistoryEvent{
+			Time:           *rec.ApproximateCreationDateTime,
+			SequenceNumber: aws.StringValue(rec.SequenceNumber),
+			EventName:      rec.EventName,
+			Changes:        DiffImages(rec.OldImage, rec.NewImage),
+		})
+	}
+	return events, nil
+}
+
+// MatchesKey checks that every condition matches one of the record's key attributes
+func (s *StreamRecordWrapper) MatchesKey(key []KeyCondition) bool {
+	for _, cond := range key {
+		av, ok := s.Keys[cond.Name]
+		if !ok {
+			return false
+		}
+		if aws.StringValue(av.S) != cond.Value && aws.StringValue(av.N) != cond.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffImages lists the attributes that differ between two images, sorted by name
+func DiffImages(old, new map[string]*dynamodb.AttributeValue) []AttributeChange {
+	names := map[string]bool{}
+	for name := range old {
+		names[name] = true
+	}
+	for name := range new {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	var changes []AttributeChange
+	for _, name := range sorted {
+		if !reflect.DeepEqual(old[name], new[name]) {
+			changes = append(changes, AttributeChange{Name: name, Old: old[name], New: new[name]})
+		}
+	}
+	return changes
+}
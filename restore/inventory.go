@@ -0,0 +1,102 @@
+package restore
+
+import (
+	"time"
+)
+
+// HourInventory summarizes the backup objects written in one hour
+type HourInventory struct {
+	Hour     time.Time `json:"hour"`
+	Files    int       `json:"files"`
+	Bytes    int64     `json:"bytes"`
+	Records  int       `json:"records"`
+	Earliest time.Time `json:"earliest"`
+	Latest   time.Time `json:"latest"`
+}
+
+// TableInventory summarizes a table's backups hour by hour
+type TableInventory struct {
+	Table   string           `json:"table"`
+	Files   int              `json:"files"`
+	Bytes   int64            `json:"bytes"`
+	Records int              `json:"records"`
+	Hours   []*HourInventory `json:"hours"`
+	// Gaps are the hours with no files, between the first and last backed up hours or across the window
+	Gaps []time.Time `json:"gaps"`
+}
+
+// Inventory walks a table's hourly partitions in the window, reading every object to count its records
+func (a *AWS) Inventory(table string, w Window) (*TableInventory, error) {
+	objs, err := a.ListPartitionObjects(table, w)
+	if err != nil {
+		return nil, err
+	}
+	inv := &TableInventory{Table: table}
+	prefix := a.tablePrefix(table)
+	var hour *HourInventory
+	for _, obj := range objs {
+		t, _ := partitionTime(prefix, obj.Key)
+		if hour == nil || !hour.Hour.Equal(t) {
+			hour = &HourInventory{Hour: t}
+			inv.Hours = append(inv.Hours, hour)
+		}
+		recs, err := a.Get(obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		hour.Files++
+		hour.Bytes += obj.Size
+		hour.Records += len(recs)
+		for _, rec := range recs {
+			created := *rec.ApproximateCreationDateTime
+			if hour.Earliest.IsZero() || created.Before(hour.Earliest) {
+				hour.Earliest = created
+			}
+			if created.After(hour.Latest) {
+				hour.Latest = created
+			}
+		}
+		inv.Files++
+		inv.Bytes += obj.Size
+		inv.Records += len(recs)
+	}
+	inv.Gaps = inv.gaps(w)
+	return inv, nil
+}
+
+// gaps finds the hours nothing was backed up in
+func (inv *TableInventory) gaps(w Window) []time.Time {
+	var hours []time.Time
+	for _, hour := range inv.Hours {
+		hours = append(hours, hour.Hour)
+	}
+	return missingHours(hours, w)
+}
+
+// missingHours lists the hours without backups between the first and last
+// backed up hours, or across the window where it's bounded
+func missingHours(hours []time.Time, w Window) []time.Time {
+	first, last := w.Start.UTC().Truncate(time.Hour), w.End.UTC().Truncate(time.Hour)
+	if len(hours) > 0 {
+		if w.Start.IsZero() {
+			first = hours[0]
+		}
+		if w.End.IsZero() {
+			last = hours[len(hours)-1]
+		}
+	}
+	if first.IsZero() || last.IsZero() {
+		return nil
+	}
+	covered := map[time.Time]bool{}
+	for _, hour := range hours {
+		covered[hour] = true
+	}
+	var gaps []time.Time
+	for h := first; !h.After(last); h = h.Add(time.Hour) {
+		if !covered[h] {
+			gaps = append(gaps, h)
+		}
+	}
+	return gaps
+}
@@ -0,0 +1,350 @@
+package restore
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Annotations DynamoDB exports put on lists that are really sets
+const (
+	ionStringSet = "$dynamodb_SS"
+	ionNumberSet = "$dynamodb_NS"
+	ionBinarySet = "$dynamodb_BS"
+	ionVersion   = "$ion_1_0"
+)
+
+// ionReader parses the subset of the Ion text format DynamoDB exports write:
+// structs, lists, strings, symbols, numbers, blobs, booleans, nulls and the set annotations
+type ionReader struct {
+	s   string
+	pos int
+}
+
+// parseIonItems reads every top level value of an Ion export object, skipping the version marker
+func parseIonItems(data string) ([]map[string]*dynamodb.AttributeValue, error) {
+	r := &ionReader{s: data}
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.s) {
+			return items, nil
+		}
+		if strings.HasPrefix(r.s[r.pos:], ionVersion) {
+			r.pos += len(ionVersion)
+			continue
+		}
+		av, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		if av.M == nil {
+			return nil, r.errorf("expected a struct")
+		}
+		item, ok := av.M["Item"]
+		if !ok || item.M == nil {
+			return nil, r.errorf("expected an Item struct")
+		}
+		items = append(items, item.M)
+	}
+}
+
+func (r *ionReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Error: ion offset %d: %s", r.pos, fmt.Sprintf(format, args...))
+}
+
+func (r *ionReader) skipSpace() {
+	for r.pos < len(r.s) {
+		switch {
+		case strings.ContainsRune(" \t\r\n,", rune(r.s[r.pos])):
+			r.pos++
+		case strings.HasPrefix(r.s[r.pos:], "//"):
+			for r.pos < len(r.s) && r.s[r.pos] != '\n' {
+				r.pos++
+			}
+		case strings.HasPrefix(r.s[r.pos:], "/*"):
+			end := strings.Index(r.s[r.pos+2:], "*/")
+			if end < 0 {
+				r.pos = len(r.s)
+				return
+			}
+			r.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func (r *ionReader) peek() byte {
+	if r.pos >= len(r.s) {
+		return 0
+	}
+	return r.s[r.pos]
+}
+
+func (r *ionReader) expect(c byte) error {
+	r.skipSpace()
+	if r.peek() != c {
+		return r.errorf("expected %q", c)
+	}
+	r.pos++
+	return nil
+}
+
+// value reads one value along with any annotations on it
+func (r *ionReader) value() (*dynamodb.AttributeValue, error) {
+	r.skipSpace()
+	var annotation string
+	for {
+		start := r.pos
+		symbol, ok, err := r.symbol()
+		if err != nil {
+			return nil, err
+		}
+		r.skipSpace()
+		if ok && strings.HasPrefix(r.s[r.pos:], "::") {
+			annotation = symbol
+			r.pos += 2
+			r.skipSpace()
+			continue
+		}
+		r.pos = start
+		break
+	}
+	switch c := r.peek(); {
+	case c == '{' && strings.HasPrefix(r.s[r.pos:], "{{"):
+		b, err := r.blob()
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{B: b}, nil
+	case c == '{':
+		return r.structValue()
+	case c == '[':
+		return r.list(annotation)
+	case c == '"':
+		s, err := r.quoted('"')
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{S: aws.String(s)}, nil
+	case c == '-' || c == '+' || (c >= '0' && c <= '9'):
+		n, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+		return &dynamodb.AttributeValue{N: aws.String(n)}, nil
+	}
+	symbol, ok, err := r.symbol()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !ok:
+		return nil, r.errorf("unexpected %q", r.peek())
+	case symbol == "true" || symbol == "false":
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(symbol == "true")}, nil
+	case symbol == "null" || strings.HasPrefix(symbol, "null."):
+		return &dynamodb.AttributeValue{NULL: aws.Bool(true)}, nil
+	}
+	// Bare symbols are strings as far as DynamoDB is concerned
+	return &dynamodb.AttributeValue{S: aws.String(symbol)}, nil
+}
+
+func (r *ionReader) structValue() (*dynamodb.AttributeValue, error) {
+	r.pos++
+	m := map[string]*dynamodb.AttributeValue{}
+	for {
+		r.skipSpace()
+		if r.peek() == '}' {
+			r.pos++
+			return &dynamodb.AttributeValue{M: m}, nil
+		}
+		var name string
+		var err error
+		if c := r.peek(); c == '"' || c == '\'' {
+			name, err = r.quoted(c)
+		} else {
+			var ok bool
+			name, ok, err = r.symbol()
+			if err == nil && !ok {
+				err = r.errorf("expected a field name")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := r.expect(':'); err != nil {
+			return nil, err
+		}
+		av, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		m[name] = av
+	}
+}
+
+func (r *ionReader) list(annotation string) (*dynamodb.AttributeValue, error) {
+	r.pos++
+	var values []*dynamodb.AttributeValue
+	for {
+		r.skipSpace()
+		if r.peek() == ']' {
+			r.pos++
+			break
+		}
+		if r.pos >= len(r.s) {
+			return nil, r.errorf("unterminated list")
+		}
+		av, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, av)
+	}
+	av := &dynamodb.AttributeValue{}
+	switch annotation {
+	case ionStringSet:
+		for _, v := range values {
+			if v.S == nil {
+				return nil, r.errorf("string set holds a non string")
+			}
+			av.SS = append(av.SS, v.S)
+		}
+	case ionNumberSet:
+		for _, v := range values {
+			if v.N == nil {
+				return nil, r.errorf("number set holds a non number")
+			}
+			av.NS = append(av.NS, v.N)
+		}
+	case ionBinarySet:
+		for _, v := range values {
+			if v.B == nil {
+				return nil, r.errorf("binary set holds a non blob")
+			}
+			av.BS = append(av.BS, v.B)
+		}
+	default:
+		if values == nil {
+			values = []*dynamodb.AttributeValue{}
+		}
+		av.L = values
+	}
+	return av, nil
+}
+
+// symbol reads an identifier or a quoted symbol, reporting false if there isn't one
+func (r *ionReader) symbol() (string, bool, error) {
+	if r.peek() == '\'' {
+		if strings.HasPrefix(r.s[r.pos:], "'''") {
+			return "", false, nil
+		}
+		s, err := r.quoted('\'')
+		return s, err == nil, err
+	}
+	start := r.pos
+	for r.pos < len(r.s) {
+		c := r.s[r.pos]
+		if c == '_' || c == '$' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (r.pos > start && c >= '0' && c <= '9') {
+			r.pos++
+			continue
+		}
+		break
+	}
+	return r.s[start:r.pos], r.pos > start, nil
+}
+
+func (r *ionReader) quoted(quote byte) (string, error) {
+	r.pos++
+	var b strings.Builder
+	for r.pos < len(r.s) {
+		c := r.s[r.pos]
+		switch {
+		case c == quote:
+			r.pos++
+			return b.String(), nil
+		case c == '\\':
+			if err := r.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(r.s[r.pos:])
+			b.WriteString(r.s[r.pos : r.pos+size])
+			r.pos += size
+		}
+	}
+	return "", r.errorf("unterminated string")
+}
+
+func (r *ionReader) escape(b *strings.Builder) error {
+	if r.pos+1 >= len(r.s) {
+		return r.errorf("unterminated escape")
+	}
+	c := r.s[r.pos+1]
+	r.pos += 2
+	simple := map[byte]string{'n': "\n", 't': "\t", 'r': "\r", '0': "\x00", 'a': "\a", 'b': "\b",
+		'f': "\f", 'v': "\v", '"': "\"", '\'': "'", '\\': "\\", '/': "/", '?': "?"}
+	if s, ok := simple[c]; ok {
+		b.WriteString(s)
+		return nil
+	}
+	digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+	if digits == 0 || r.pos+digits > len(r.s) {
+		return r.errorf("bad escape \\%c", c)
+	}
+	code, err := strconv.ParseUint(r.s[r.pos:r.pos+digits], 16, 32)
+	if err != nil {
+		return r.errorf("bad escape \\%c", c)
+	}
+	r.pos += digits
+	b.WriteRune(rune(code))
+	return nil
+}
+
+// number reads an int, decimal or float and rewrites it the way DynamoDB writes numbers
+func (r *ionReader) number() (string, error) {
+	start := r.pos
+	for r.pos < len(r.s) && strings.IndexByte("+-0123456789._eEdDxXabcdefABCDEF", r.s[r.pos]) >= 0 {
+		r.pos++
+	}
+	raw := strings.Replace(r.s[start:r.pos], "_", "", -1)
+	lower := strings.ToLower(raw)
+	if strings.HasPrefix(strings.TrimLeft(lower, "+-"), "0x") {
+		n, err := strconv.ParseInt(lower, 0, 64)
+		if err != nil {
+			return "", r.errorf("bad number %s", raw)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	// Decimals use d for their exponent and may end in a bare point
+	n := strings.Replace(lower, "d", "e", 1)
+	n = strings.Replace(n, ".e", "e", 1)
+	n = strings.TrimSuffix(n, ".")
+	if _, err := strconv.ParseFloat(n, 64); err != nil && !errors.Is(err, strconv.ErrRange) {
+		return "", r.errorf("bad number %s", raw)
+	}
+	return n, nil
+}
+
+func (r *ionReader) blob() ([]byte, error) {
+	r.pos += 2
+	end := strings.Index(r.s[r.pos:], "}}")
+	if end < 0 {
+		return nil, r.errorf("unterminated blob")
+	}
+	encoded := strings.Join(strings.Fields(r.s[r.pos:r.pos+end]), "")
+	r.pos += end + 2
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, r.errorf("bad blob: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,121 @@
+package restore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestParseIonItems(t *testing.T) {
+	tests := []struct {
+		name string
+		ion  string
+		// want is the items as DynamoDB JSON
+		want string
+	}{
+		{"version marker",
+			`$ion_1_0 {Item:{id:"a"}}`,
+			`[{"id":{"S":"a"}}]`},
+		{"several items",
+			"$ion_1_0\n{Item:{id:\"a\"}}\n{Item:{id:\"b\"}}\n",
+			`[{"id":{"S":"a"}},{"id":{"S":"b"}}]`},
+		{"comments",
+			"// written by an export\n{Item:{/* the key */ id:\"a\", // trailing\n n:1}}",
+			`[{"id":{"S":"a"},"n":{"N":"1"}}]`},
+		{"string set",
+			`{Item:{ss:$dynamodb_SS::["a","b"]}}`,
+			`[{"ss":{"SS":["a","b"]}}]`},
+		{"number set",
+			`{Item:{ns:$dynamodb_NS::[1,2.5]}}`,
+			`[{"ns":{"NS":["1","2.5"]}}]`},
+		{"binary set",
+			`{Item:{bs:$dynamodb_BS::[{{aGVsbG8=}},{{d29y bGQ=}}]}}`,
+			`[{"bs":{"BS":["aGVsbG8=","d29ybGQ="]}}]`},
+		{"blob",
+			`{Item:{b:{{ aGVsbG8= }}}}`,
+			`[{"b":{"B":"aGVsbG8="}}]`},
+		{"decimal with d exponent",
+			`{Item:{n:1.5d3}}`,
+			`[{"n":{"N":"1.5e3"}}]`},
+		{"decimal with bare point and exponent",
+			`{Item:{n:12.d-2}}`,
+			`[{"n":{"N":"12e-2"}}]`},
+		{"decimal with bare point",
+			`{Item:{n:12.}}`,
+			`[{"n":{"N":"12"}}]`},
+		{"float",
+			`{Item:{n:-2.5e10}}`,
+			`[{"n":{"N":"-2.5e10"}}]`},
+		{"underscores",
+			`{Item:{n:1_000}}`,
+			`[{"n":{"N":"1000"}}]`},
+		{"hex",
+			`{Item:{n:0x1F}}`,
+			`[{"n":{"N":"31"}}]`},
+		{"negative hex",
+			`{Item:{n:-0x10}}`,
+			`[{"n":{"N":"-16"}}]`},
+		{"escapes",
+			`{Item:{s:"tab\tnewline\nquote\"hex\x41unicodeé"}}`,
+			`[{"s":{"S":"tab\tnewline\nquote\"hexAunicodeé"}}]`},
+		{"quoted field names and symbols",
+			`{Item:{'odd name':'quoted symbol', "string name":bare}}`,
+			`[{"odd name":{"S":"quoted symbol"},"string name":{"S":"bare"}}]`},
+		{"booleans and nulls",
+			`{Item:{t:true, f:false, n:null, ns:null.string}}`,
+			`[{"f":{"BOOL":false},"n":{"NULL":true},"ns":{"NULL":true},"t":{"BOOL":true}}]`},
+		{"lists and maps",
+			`{Item:{l:[1,"a",[]], m:{nested:{deep:true}}}}`,
+			`[{"l":{"L":[{"N":"1"},{"S":"a"},{"L":[]}]},"m":{"M":{"nested":{"M":{"deep":{"BOOL":true}}}}}}]`},
+		{"empty",
+			"$ion_1_0\n",
+			`null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parseIonItems(tt.ion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []map[string]*dynamodb.AttributeValue
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(items)
+			wantJSON, _ := json.Marshal(want)
+			if string(got) != string(wantJSON) {
+				t.Errorf("got %s, want %s", got, wantJSON)
+			}
+		})
+	}
+}
+
+func TestParseIonItemsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ion  string
+	}{
+		{"not a struct", `[1]`},
+		{"no Item", `{Other:{id:"a"}}`},
+		{"Item isn't a struct", `{Item:"a"}`},
+		{"unterminated string", `{Item:{s:"abc}}`},
+		{"unterminated list", `{Item:{l:[1,2`},
+		{"unterminated blob", `{Item:{b:{{aGVsbG8=}}`},
+		{"bad blob", `{Item:{b:{{not base64!}}}}`},
+		{"bad escape", `{Item:{s:"\q"}}`},
+		{"bad number", `{Item:{n:1.2.3}}`},
+		{"bad hex", `{Item:{n:0xZZ}}`},
+		{"string set holding a number", `{Item:{ss:$dynamodb_SS::[1]}}`},
+		{"number set holding a string", `{Item:{ns:$dynamodb_NS::["a"]}}`},
+		{"binary set holding a string", `{Item:{bs:$dynamodb_BS::["a"]}}`},
+		{"missing colon", `{Item:{id "a"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if items, err := parseIonItems(tt.ion); err == nil {
+				t.Errorf("expected an error, got %v", items)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const redacted = "[redacted]"
+
+// Item logs the attributes of an item or key. Behind a redacting handler only
+// the attribute names are logged, so item data stays out of the logs
+type Item map[string]*dynamodb.AttributeValue
+
+// LogValue logs every attribute with its value
+func (i Item) LogValue() slog.Value {
+	var attrs []slog.Attr
+	for name, av := range i {
+		attrs = append(attrs, slog.String(name, FormatAttributeValue(av)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func (i Item) redactedValue() slog.Value {
+	var attrs []slog.Attr
+	for name := range i {
+		attrs = append(attrs, slog.String(name, redacted))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// NewLogger creates a logger writing text or json at the given level, redacting item values unless told not to
+func NewLogger(w io.Writer, format string, level slog.Level, redact bool) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, errors.New("Error: Unknown log format " + format)
+	}
+	if redact {
+		h = NewRedactingHandler(h)
+	}
+	return slog.New(h), nil
+}
+
+// NewRedactingHandler wraps a handler so Item values are logged as attribute names only
+func NewRedactingHandler(h slog.Handler) slog.Handler {
+	return redactingHandler{h}
+}
+
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redact(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = redact(a)
+	}
+	return redactingHandler{h.Handler.WithAttrs(redactedAttrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+func redact(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindLogValuer, slog.KindAny:
+		if item, ok := a.Value.Any().(Item); ok {
+			return slog.Attr{Key: a.Key, Value: item.redactedValue()}
+		}
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redactedGroup := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redactedGroup[i] = redact(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactedGroup...)}
+	}
+	return a
+}
+
+// logger falls back to the default logger when none was set
+func (a *AWS) logger() *slog.Logger {
+	if a.Logger != nil {
+		return a.Logger
+	}
+	return defaultLogger()
+}
+
+// defaultLogger is slog's default logger behind a redacting handler, so item
+// data stays out of the logs when no logger was set
+func defaultLogger() *slog.Logger {
+	return slog.New(NewRedactingHandler(slog.Default().Handler()))
+}
@@ -0,0 +1,243 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// batchWriteBuckets are the upper bounds in seconds of the batch write latency histogram
+var batchWriteBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics counts what restores read and write, per table, and serves them in
+// the Prometheus text format. A nil Metrics records nothing
+type Metrics struct {
+	objectsFetched   *counterVec
+	bytesRead        *counterVec
+	decodeErrors     *counterVec
+	batchWrites      *histogramVec
+	itemsWritten     *counterVec
+	itemsFailed      *counterVec
+	retries          *counterVec
+	throttles        *counterVec
+	consumedCapacity *counterVec
+	lastWrite        *counterVec
+}
+
+// NewMetrics creates an empty set of restore metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		objectsFetched:   newCounterVec("restore_objects_fetched_total", "counter", "Backup objects fetched from S3."),
+		bytesRead:        newCounterVec("restore_bytes_read_total", "counter", "Bytes of backup objects read from S3, before decompression."),
+		decodeErrors:     newCounterVec("restore_decode_errors_total", "counter", "Backup lines that couldn't be decoded."),
+		batchWrites:      newHistogramVec("restore_batch_write_duration_seconds", "Latency of BatchWriteItem requests.", batchWriteBuckets),
+		itemsWritten:     newCounterVec("restore_items_written_total", "counter", "Items written to DynamoDB."),
+		itemsFailed:      newCounterVec("restore_items_failed_total", "counter", "Items that couldn't be written to DynamoDB."),
+		retries:          newCounterVec("restore_unprocessed_item_retries_total", "counter", "Unprocessed items sent again."),
+		throttles:        newCounterVec("restore_throttles_total", "counter", "Write calls rejected or left with unprocessed items for exceeding provisioned throughput."),
+		consumedCapacity: newCounterVec("restore_consumed_capacity_units_total", "counter", "Write capacity units consumed."),
+		lastWrite:        newCounterVec("restore_last_write_timestamp_seconds", "gauge", "Unix time of the last successful write, for spotting stalled restores."),
+	}
+}
+
+func (m *Metrics) objectFetched(table string, bytes int64) {
+	if m != nil {
+		m.objectsFetched.add(table, 1)
+		m.bytesRead.add(table, float64(bytes))
+	}
+}
+
+func (m *Metrics) decodeError(table string) {
+	if m != nil {
+		m.decodeErrors.add(table, 1)
+	}
+}
+
+func (m *Metrics) batchWritten(table string, took time.Duration, written, failed int, capacity float64) {
+	if m == nil {
+		return
+	}
+	m.batchWrites.observe(table, took.Seconds())
+	m.itemsWritten.add(table, float64(written))
+	m.itemsFailed.add(table, float64(failed))
+	m.consumedCapacity.add(table, capacity)
+	if written > 0 {
+		m.lastWrite.set(table, float64(time.Now().Unix()))
+	}
+}
+
+// itemWritten counts an item written on its own rather than in a batch
+func (m *Metrics) itemWritten(table string, capacity float64) {
+	if m != nil {
+		m.itemsWritten.add(table, 1)
+		m.consumedCapacity.add(table, capacity)
+		m.lastWrite.set(table, float64(time.Now().Unix()))
+	}
+}
+
+func (m *Metrics) itemsFailedToWrite(table string, items int) {
+	if m != nil {
+		m.itemsFailed.add(table, float64(items))
+	}
+}
+
+func (m *Metrics) retried(table string, items int) {
+	if m != nil {
+		m.retries.add(table, float64(items))
+	}
+}
+
+func (m *Metrics) writeError(table string, err error) {
+	if isThrottle(err) {
+		m.throttled(table)
+	}
+}
+
+// throttled counts a write call that was rejected or left items unprocessed for exceeding throughput
+func (m *Metrics) throttled(table string) {
+	if m != nil {
+		m.throttles.add(table, 1)
+	}
+}
+
+// ServeHTTP writes every metric in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, c := range []*counterVec{m.objectsFetched, m.bytesRead, m.decodeErrors} {
+		c.write(&b)
+	}
+	m.batchWrites.write(&b)
+	for _, c := range []*counterVec{m.itemsWritten, m.itemsFailed, m.retries, m.throttles, m.consumedCapacity, m.lastWrite} {
+		c.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func isThrottle(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException, "ThrottlingException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// counterVec is a counter or gauge with a table label
+type counterVec struct {
+	name, kind, help string
+	mu               sync.Mutex
+	values           map[string]float64
+}
+
+func newCounterVec(name, kind, help string) *counterVec {
+	return &counterVec{name: name, kind: kind, help: help, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(table string, v float64) {
+	c.mu.Lock()
+	c.values[table] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) set(table string, v float64) {
+	c.mu.Lock()
+	c.values[table] = v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+	for _, table := range sortedLabels(c.values) {
+		fmt.Fprintf(b, "%s{table=%q} %s\n", c.name, table, formatFloat(c.values[table]))
+	}
+}
+
+// histogramVec is a histogram with a table label
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(table string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[table]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[table] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	tables := make([]string, 0, len(h.series))
+	for table := range h.series {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		s := h.series[table]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket{table=%q,le=%q} %d\n", h.name, table, formatFloat(bound), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket{table=%q,le=\"+Inf\"} %d\n", h.name, table, s.count)
+		fmt.Fprintf(b, "%s_sum{table=%q} %s\n", h.name, table, formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count{table=%q} %d\n", h.name, table, s.count)
+	}
+}
+
+func sortedLabels(values map[string]float64) []string {
+	labels := make([]string, 0, len(values))
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,120 @@
+package restore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/goamz/goamz/s3"
+)
+
+const (
+	// Backups are written to prefix/table/YYYY/MM/DD/HH/
+	partitionLayout = "2006/01/02/15"
+	dayLayout       = "2006/01/02/"
+	day             = 24 * time.Hour
+	// Records can be delivered into the hour after the one they were made in
+	deliverySlack = time.Hour
+)
+
+// Window is a range of time to read backups from. A zero Start or End leaves that side open
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Contains checks whether a point in time falls in the window
+func (w Window) Contains(t time.Time) bool {
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && t.After(w.End) {
+		return false
+	}
+	return true
+}
+
+// overlapsPartition checks whether a partition starting at hour can hold records in the window
+func (w Window) overlapsPartition(hour time.Time) bool {
+	if !w.Start.IsZero() && !hour.Add(time.Hour).After(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && hour.After(w.End.Add(deliverySlack)) {
+		return false
+	}
+	return true
+}
+
+func (a *AWS) tablePrefix(table string) string {
+	return a.Config.Prefix + table + "/"
+}
+
+// tableOfKey is the table a backup object belongs to
+func (a *AWS) tableOfKey(key string) string {
+	rest := strings.TrimPrefix(key, a.Config.Prefix)
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i]
+	}
+	return rest
+}
+
+// partitionTime parses the hour a backup object was written in from its key
+func partitionTime(tablePrefix, key string) (time.Time, bool) {
+	if !strings.HasPrefix(key, tablePrefix) {
+		return time.Time{}, false
+	}
+	rest := key[len(tablePrefix):]
+	if len(rest) < len(partitionLayout) {
+		return time.Time{}, false
+	}
+	hour, err := time.Parse(partitionLayout, rest[:len(partitionLayout)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return hour, true
+}
+
+// ListPartitions lists a table's backup objects that can hold records in the window, oldest first
+func (a *AWS) ListPartitions(table string, w Window) ([]string, error) {
+	objs, err := a.ListPartitionObjects(table, w)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, obj := range objs {
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// ListPartitionObjects is ListPartitions, keeping the size and ETag S3 lists for each object
+func (a *AWS) ListPartitionObjects(table string, w Window) ([]s3.Key, error) {
+	prefix := a.tablePrefix(table)
+	prefixes := []string{prefix}
+	if !w.Start.IsZero() {
+		// Only list the days we need instead of the whole table
+		end := w.End
+		if end.IsZero() {
+			end = time.Now()
+		}
+		end = end.Add(deliverySlack)
+		prefixes = nil
+		for d := w.Start.UTC().Truncate(day); !d.After(end); d = d.Add(day) {
+			prefixes = append(prefixes, prefix+d.Format(dayLayout))
+		}
+	}
+	var objs []s3.Key
+	for _, p := range prefixes {
+		listed, err := a.ListObjects(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range listed {
+			hour, ok := partitionTime(prefix, obj.Key)
+			if ok && w.overlapsPartition(hour) {
+				objs = append(objs, obj)
+			}
+		}
+	}
+	// Day prefixes are listed in order and S3 lists keys in order, so objs is already sorted
+	return objs, nil
+}
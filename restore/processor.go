@@ -0,0 +1,139 @@
+package restore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Processor changes, drops or splits records on their way into the target table.
+// Returning no records drops the one given
+type Processor interface {
+	Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error)
+}
+
+// ProcessorFunc lets a plain function be used as a Processor
+type ProcessorFunc func(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error)
+
+// Process calls f
+func (f ProcessorFunc) Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error) {
+	return f(ctx, rec)
+}
+
+type chain []Processor
+
+// Chain runs processors in order, passing every record one returns on to the next
+func Chain(procs ...Processor) Processor {
+	return chain(procs)
+}
+
+func (c chain) Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error) {
+	recs := []*StreamRecordWrapper{rec}
+	for _, p := range c {
+		var next []*StreamRecordWrapper
+		for _, r := range recs {
+			out, err := p.Process(ctx, r)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, out...)
+		}
+		recs = next
+	}
+	return recs, nil
+}
+
+// ProcessAll runs every record through a processor
+func ProcessAll(ctx context.Context, p Processor, recs StreamRecordWrappers) (StreamRecordWrappers, error) {
+	var processed StreamRecordWrappers
+	for _, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		out, err := p.Process(ctx, rec)
+		if err != nil {
+			return nil, err
+		}
+		processed = append(processed, out...)
+	}
+	return processed, nil
+}
+
+// Process keeps the records that match the filter
+func (f *Filter) Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error) {
+	if f.Match(rec) {
+		return []*StreamRecordWrapper{rec}, nil
+	}
+	return nil, nil
+}
+
+// Process applies the transforms to a record
+func (ts Transforms) Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error) {
+	ts.Apply(rec)
+	return []*StreamRecordWrapper{rec}, nil
+}
+
+// ExecProcessor pipes records through a long running external command. For each
+// record it writes one backup format JSON line to the command's stdin, and reads
+// back one line holding a JSON array of the records to keep, which can be empty.
+// With jq that looks like: jq -c --unbuffered '[select(.eventName != "REMOVE")]'
+type ExecProcessor struct {
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+// NewExecProcessor starts a shell command to process records with. Its stderr goes to ours
+func NewExecProcessor(command string) (*ExecProcessor, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &ExecProcessor{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// Process sends a record through the command and reads back what it returns
+func (e *ExecProcessor) Process(ctx context.Context, rec *StreamRecordWrapper) ([]*StreamRecordWrapper, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.in.Write(append(line, '\n')); err != nil {
+		return nil, errors.New("Error: writing to exec hook: " + err.Error())
+	}
+	reply, err := e.out.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.New("Error: reading from exec hook: " + err.Error())
+	}
+	var recs []*StreamRecordWrapper
+	if err := json.Unmarshal(reply, &recs); err != nil {
+		return nil, errors.New("Error: exec hook has to reply with a JSON array of records per line: " + err.Error())
+	}
+	return recs, nil
+}
+
+// Close ends the command's input and waits for it to exit
+func (e *ExecProcessor) Close() error {
+	e.in.Close()
+	return e.cmd.Wait()
+}
@@ -0,0 +1,225 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	progressBarWidth   = 30
+	progressTTYRefresh = 250 * time.Millisecond
+)
+
+// Progress counts a restore's work as it happens. It's safe for concurrent use
+type Progress struct {
+	filesListed    atomic.Int64
+	filesRead      atomic.Int64
+	recordsDecoded atomic.Int64
+	keysCompacted  atomic.Int64
+	itemsWritten   atomic.Int64
+
+	mu       sync.Mutex
+	capacity float64
+	started  time.Time
+	writing  time.Time
+}
+
+// ProgressSnapshot is the state of a restore at one moment
+type ProgressSnapshot struct {
+	FilesListed    int64
+	FilesRead      int64
+	RecordsDecoded int64
+	// KeysCompacted counts distinct keys left to write once duplicate changes are dropped
+	KeysCompacted int64
+	ItemsWritten  int64
+	// CapacityUnits is the write capacity consumed so far
+	CapacityUnits float64
+	Elapsed       time.Duration
+	// Throughput is items written per second since writing started
+	Throughput float64
+	// ETA is zero until there's enough to estimate from
+	ETA time.Duration
+}
+
+// NewProgress starts counting from now
+func NewProgress() *Progress {
+	return &Progress{started: time.Now()}
+}
+
+type progressKey struct{}
+
+// ContextWithProgress lets sinks and sources further down report into p
+func ContextWithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// ProgressFromContext returns the Progress carried by ctx. A nil Progress ignores everything reported to it
+func ProgressFromContext(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// Listed counts backup objects found
+func (p *Progress) Listed(n int) {
+	if p != nil {
+		p.filesListed.Add(int64(n))
+	}
+}
+
+// Read counts a downloaded object and the records decoded from it
+func (p *Progress) Read(records int) {
+	if p != nil {
+		p.filesRead.Add(1)
+		p.recordsDecoded.Add(int64(records))
+	}
+}
+
+// Compacted counts keys left to write after duplicates are removed
+func (p *Progress) Compacted(keys int) {
+	if p == nil {
+		return
+	}
+	p.keysCompacted.Add(int64(keys))
+	p.mu.Lock()
+	if p.writing.IsZero() {
+		p.writing = time.Now()
+	}
+	p.mu.Unlock()
+}
+
+// Wrote counts items written and the write capacity they consumed
+func (p *Progress) Wrote(items int, capacity float64) {
+	if p == nil {
+		return
+	}
+	p.itemsWritten.Add(int64(items))
+	p.mu.Lock()
+	p.capacity += capacity
+	p.mu.Unlock()
+}
+
+// Snapshot reads the counters and estimates the time left
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	capacity, started, writing := p.capacity, p.started, p.writing
+	p.mu.Unlock()
+	s := ProgressSnapshot{
+		FilesListed:    p.filesListed.Load(),
+		FilesRead:      p.filesRead.Load(),
+		RecordsDecoded: p.recordsDecoded.Load(),
+		KeysCompacted:  p.keysCompacted.Load(),
+		ItemsWritten:   p.itemsWritten.Load(),
+		CapacityUnits:  capacity,
+		Elapsed:        time.Since(started),
+	}
+	if !writing.IsZero() {
+		if secs := time.Since(writing).Seconds(); secs > 0 {
+			s.Throughput = float64(s.ItemsWritten) / secs
+		}
+		if s.Throughput > 0 && s.KeysCompacted > s.ItemsWritten {
+			s.ETA = time.Duration(float64(s.KeysCompacted-s.ItemsWritten) / s.Throughput * float64(time.Second))
+		}
+	} else if s.FilesRead > 0 && s.FilesListed > s.FilesRead {
+		// Still reading, so estimate from how fast objects come in
+		perFile := s.Elapsed / time.Duration(s.FilesRead)
+		s.ETA = perFile * time.Duration(s.FilesListed-s.FilesRead)
+	}
+	return s
+}
+
+// Fraction is how far along the current stage is, from 0 to 1
+func (s ProgressSnapshot) Fraction() float64 {
+	if s.KeysCompacted > 0 {
+		return math.Min(1, float64(s.ItemsWritten)/float64(s.KeysCompacted))
+	}
+	if s.FilesListed > 0 {
+		return math.Min(1, float64(s.FilesRead)/float64(s.FilesListed))
+	}
+	return 0
+}
+
+// String is a one line summary, as drawn after the bar
+func (s ProgressSnapshot) String() string {
+	stage := "reading"
+	if s.KeysCompacted > 0 {
+		stage = "writing"
+	}
+	eta := "-"
+	if s.ETA > 0 {
+		eta = s.ETA.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s %3.0f%% files %d/%d records %d keys %d written %d %.1f WCU %.0f items/s ETA %s",
+		stage, s.Fraction()*100, s.FilesRead, s.FilesListed, s.RecordsDecoded, s.KeysCompacted,
+		s.ItemsWritten, s.CapacityUnits, s.Throughput, eta)
+}
+
+// LogAttrs are the snapshot's fields for a log line
+func (s ProgressSnapshot) LogAttrs() []interface{} {
+	return []interface{}{
+		"filesListed", s.FilesListed,
+		"filesRead", s.FilesRead,
+		"recordsDecoded", s.RecordsDecoded,
+		"keysCompacted", s.KeysCompacted,
+		"itemsWritten", s.ItemsWritten,
+		"capacityUnits", s.CapacityUnits,
+		"itemsPerSecond", math.Round(s.Throughput),
+		"eta", s.ETA.Round(time.Second),
+	}
+}
+
+// Render draws a bar on out if it's a terminal, otherwise logs a line every
+// interval. It stops, drawing or logging once more, when the returned func is called
+func (p *Progress) Render(out *os.File, logger *slog.Logger, interval time.Duration) (stop func()) {
+	tty := isTerminal(out)
+	draw := func() { logger.Info("Progress", p.Snapshot().LogAttrs()...) }
+	if tty {
+		interval = progressTTYRefresh
+		draw = func() { drawBar(out, p.Snapshot()) }
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				draw()
+			case <-done:
+				draw()
+				if tty {
+					fmt.Fprintln(out)
+				}
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// drawBar redraws the current line
+func drawBar(w io.Writer, s ProgressSnapshot) {
+	filled := int(s.Fraction() * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	fmt.Fprintf(w, "\r\033[K[%s] %s", bar, s)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
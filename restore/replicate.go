@@ -0,0 +1,102 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// replicateCheckpoint names a replication's checkpoint, which is per target so a source can feed several
+const replicateCheckpoint = "replicate-"
+
+// ReplicateOptions configures Replicate
+type ReplicateOptions struct {
+	// Follow keeps applying changes until ctx is done instead of stopping once caught up
+	Follow bool
+	// PollInterval is how long to wait between polls once caught up, defaulting to 10s
+	PollInterval time.Duration
+	// Checkpoints stores how far each source's stream has been applied
+	Checkpoints Checkpoints
+}
+
+// Replicate applies changes from the streams of the restorer's source tables
+// straight to their targets, without going through S3. streams reads the
+// sources' streams, while the restorer's sink, filter and processors apply the
+// changes the same way a restore would. Every page of records read from a shard
+// is written before the shard's checkpoint moves past it, and shards are read
+// after their parents, so changes to each key are applied in order. A page with
+// any failed writes stops the table's replication without moving its
+// checkpoint, so nothing is skipped when it's run again. Tables are
+// replicated side by side, and the report totals what each has applied
+func (r *Restorer) Replicate(ctx context.Context, streams *AWS, opts ReplicateOptions) (*Report, error) {
+	if r.dryRun {
+		return nil, errors.New("Error: replication can't be a dry run, since it checkpoints what it's applied")
+	}
+	if opts.Checkpoints == nil {
+		return nil, errors.New("Error: replication needs somewhere to keep checkpoints")
+	}
+	report := &Report{StartedAt: time.Now()}
+	if r.progress != nil {
+		ctx = ContextWithProgress(ctx, r.progress)
+	}
+	var wg sync.WaitGroup
+	for _, m := range r.tables {
+		table := &TableReport{TableMapping: m, Events: map[string]int{}, Stats: &WriteStats{}}
+		report.Tables = append(report.Tables, table)
+		wg.Add(1)
+		go func(table *TableReport) {
+			defer wg.Done()
+			if err := r.replicateTable(ctx, streams, table, opts); err != nil && ctx.Err() == nil {
+				r.logger.Error("Error replicating table", "source", table.Source, "target", table.Target, "error", err)
+				table.Error = err.Error()
+			}
+		}(table)
+	}
+	wg.Wait()
+	report.FinishedAt = time.Now()
+	if failed := report.Failed(); len(failed) > 0 {
+		return report, fmt.Errorf("Error: Failed to replicate %v", failed)
+	}
+	return report, nil
+}
+
+// replicateTable applies one source's stream to its target, adding what's applied to the table's report
+func (r *Restorer) replicateTable(ctx context.Context, streams *AWS, table *TableReport, opts ReplicateOptions) error {
+	r.logger.Info("Replicating table", "source", table.Source, "target", table.Target, "follow", opts.Follow)
+	t := &streamTail{
+		table:       table.Source,
+		checkpoints: opts.Checkpoints,
+		name:        table.Source + "/" + replicateCheckpoint + table.Target,
+		// Each page is applied as soon as it's read, keeping the target close behind
+		batch: 1,
+		handle: func(ctx context.Context, shardID string, recs StreamRecordWrappers) error {
+			applied := &TableReport{TableMapping: table.TableMapping}
+			if err := r.write(ctx, recs, applied); err != nil {
+				return err
+			}
+			table.Records += len(recs)
+			for event, n := range applied.Events {
+				table.Events[event] += n
+			}
+			table.Filtered += applied.Filtered
+			table.Stats.Add(applied.Stats)
+			if applied.LastSequenceNumber != "" {
+				table.LastSequenceNumber = applied.LastSequenceNumber
+				table.LastEventTime = applied.LastEventTime
+			}
+			if applied.Stats != nil && applied.Stats.Failed > 0 {
+				// Returning before the checkpoint moves means the page is read again once the target's fixed
+				return fmt.Errorf("Error: %d writes to %s failed, stopping at shard %s", applied.Stats.Failed, table.Target, shardID)
+			}
+			r.logger.Debug("Applied stream records", "source", table.Source, "target", table.Target, "shard", shardID,
+				"records", len(recs), "lastEvent", applied.LastEventTime)
+			return nil
+		},
+	}
+	err := streams.tailStream(ctx, t, opts.Follow, opts.PollInterval)
+	r.logger.Info("Replication stopped", "source", table.Source, "target", table.Target, "records", table.Records,
+		"written", table.Stats.Written, "lastEvent", table.LastEventTime)
+	return err
+}
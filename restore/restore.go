@@ -1,15 +1,101 @@
 package restore
 
-import "path/filepath"
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"time"
+)
 
 const (
-	gzip = ".gz"
+	gzipExt = ".gz"
 )
 
+// TableMapping pairs a backed up table with the table it's restored into
+type TableMapping struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// TableReport is the outcome of restoring one table
+type TableReport struct {
+	TableMapping
+	Objects int `json:"objectCount"`
+	// ObjectsRead lists the backup objects the records came from
+	ObjectsRead []Object `json:"objects"`
+	// Skipped lists the objects that were dead-lettered whole because they couldn't be decoded
+	Skipped []string `json:"skipped,omitempty"`
+	Records int      `json:"records"`
+	// Events counts the records to restore by event type, before filtering
+	Events map[string]int `json:"events,omitempty"`
+	// Filtered counts the records left out by the filter
+	Filtered int         `json:"filtered"`
+	Stats    *WriteStats `json:"stats,omitempty"`
+	// LastSequenceNumber and LastEventTime identify the last change applied to the target
+	LastSequenceNumber string    `json:"lastSequenceNumber,omitempty"`
+	LastEventTime      time.Time `json:"lastEventTime,omitempty"`
+	Error              string    `json:"error,omitempty"`
+}
+
+// Report is the outcome of restoring a set of tables
+type Report struct {
+	// Parameters are the settings the restore ran with, for callers to fill in
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Window     Window            `json:"window"`
+	DryRun     bool              `json:"dryRun,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
+	// CutOff is the shared point in time consistent restores stop at
+	CutOff time.Time      `json:"cutOff,omitempty"`
+	Tables []*TableReport `json:"tables"`
+}
+
+// Duration is how long the restore took
+func (r *Report) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// MarshalJSON adds the duration and totals to the report
+func (r *Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		*report
+		DurationSeconds float64     `json:"durationSeconds"`
+		Totals          *WriteStats `json:"totals"`
+	}{(*report)(r), r.Duration().Seconds(), r.Totals()})
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Totals sums the write stats of every table
+func (r *Report) Totals() *WriteStats {
+	totals := &WriteStats{}
+	for _, table := range r.Tables {
+		totals.Add(table.Stats)
+	}
+	return totals
+}
+
+// Failed lists the source tables that couldn't be restored
+func (r *Report) Failed() []string {
+	var failed []string
+	for _, table := range r.Tables {
+		if table.Error != "" {
+			failed = append(failed, table.Source)
+		}
+	}
+	return failed
+}
+
 func filterFiles(list []string) (filtered []string) {
 	for _, entry := range list {
 		ext := filepath.Ext(entry)
-		if ext == gzip {
+		if ext == gzipExt {
 			filtered = append(filtered, entry)
 		}
 	}
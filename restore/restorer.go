@@ -0,0 +1,365 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const defaultConcurrency = 4
+
+// Restorer replays backed up records from a Source into a Sink for a set of tables
+type Restorer struct {
+	source      Source
+	sink        Sink
+	tables      []TableMapping
+	window      Window
+	concurrency int
+	consistent  bool
+	dryRun      bool
+	filter      *Filter
+	processors  []Processor
+	progress    *Progress
+	logger      *slog.Logger
+	// optErr is the first error an option ran into, returned by NewRestorer
+	optErr error
+}
+
+// Option configures a Restorer
+type Option func(*Restorer)
+
+// WithSource sets where backups are read from
+func WithSource(source Source) Option {
+	return func(r *Restorer) { r.source = source }
+}
+
+// WithSink sets where records are written to
+func WithSink(sink Sink) Option {
+	return func(r *Restorer) { r.sink = sink }
+}
+
+// WithTables adds tables to restore
+func WithTables(mappings ...TableMapping) Option {
+	return func(r *Restorer) { r.tables = append(r.tables, mappings...) }
+}
+
+// WithWindow only restores records made during the window
+func WithWindow(w Window) Option {
+	return func(r *Restorer) { r.window = w }
+}
+
+// WithConcurrency sets how many backup objects are read at once
+func WithConcurrency(n int) Option {
+	return func(r *Restorer) { r.concurrency = n }
+}
+
+// WithConsistentCutOff restores every table up to one shared cut-off, the
+// latest instant all of their backups reach. How far a table's backups reach is
+// its latest record read, since a backup writer can still be holding earlier
+// records when it writes an object. A table with no records in the window
+// doesn't limit the cut-off
+func WithConsistentCutOff() Option {
+	return func(r *Restorer) { r.consistent = true }
+}
+
+// WithDryRun reads and processes records without writing them
+func WithDryRun() Option {
+	return func(r *Restorer) { r.dryRun = true }
+}
+
+// WithFilter only restores records matching the filter
+func WithFilter(f *Filter) Option {
+	return func(r *Restorer) { r.filter = f }
+}
+
+// WithTransforms changes every record before it's written. The transforms are
+// validated here, so NewRestorer fails on one that's missing what it needs
+func WithTransforms(ts Transforms) Option {
+	return func(r *Restorer) {
+		if err := ts.Validate(); err != nil && r.optErr == nil {
+			r.optErr = err
+		}
+		r.processors = append(r.processors, ts)
+	}
+}
+
+// WithProcessors adds hooks that run in order on every record after the filter
+func WithProcessors(procs ...Processor) Option {
+	return func(r *Restorer) { r.processors = append(r.processors, procs...) }
+}
+
+// WithProgress counts work done into p, which is also passed on to the source and sink through the context
+func WithProgress(p *Progress) Option {
+	return func(r *Restorer) { r.progress = p }
+}
+
+// WithLogger sets the logger, which defaults to slog's default logger with item values redacted
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Restorer) { r.logger = logger }
+}
+
+// NewRestorer creates a Restorer from options. It needs a source, tables and, unless it's a dry run, a sink
+func NewRestorer(opts ...Option) (*Restorer, error) {
+	r := &Restorer{concurrency: defaultConcurrency}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.logger == nil {
+		r.logger = defaultLogger()
+	}
+	if r.optErr != nil {
+		return nil, r.optErr
+	}
+	if r.source == nil {
+		return nil, errors.New("Error: restorer needs a source")
+	}
+	if r.sink == nil && !r.dryRun {
+		return nil, errors.New("Error: restorer needs a sink")
+	}
+	if len(r.tables) == 0 {
+		return nil, errors.New("Error: restorer needs tables to restore")
+	}
+	if r.concurrency < 1 {
+		return nil, errors.New("Error: restorer concurrency has to be at least 1")
+	}
+	return r, nil
+}
+
+// Run restores every table, carrying on past failures so the report covers all
+// of them. It returns an error if any table failed or ctx was cancelled
+func (r *Restorer) Run(ctx context.Context) (*Report, error) {
+	report := &Report{Window: r.window, DryRun: r.dryRun, StartedAt: time.Now()}
+	if r.progress != nil {
+		ctx = ContextWithProgress(ctx, r.progress)
+	}
+	if r.consistent {
+		r.runConsistently(ctx, report)
+	} else {
+		for _, m := range r.tables {
+			table := &TableReport{TableMapping: m}
+			report.Tables = append(report.Tables, table)
+			recs, err := r.load(ctx, table)
+			if err == nil {
+				err = r.write(ctx, recs, table)
+			}
+			if err == nil {
+				err = table.incomplete()
+			}
+			if err != nil {
+				r.logger.Error("Error restoring table", "source", m.Source, "target", m.Target, "error", err)
+				table.Error = err.Error()
+			}
+		}
+	}
+	report.FinishedAt = time.Now()
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		return report, fmt.Errorf("Error: Failed to restore %v", failed)
+	}
+	return report, nil
+}
+
+// runConsistently reads every table before writing any of them, so they can
+// all be cut off at the latest instant every table's backups reach
+func (r *Restorer) runConsistently(ctx context.Context, report *Report) {
+	report.CutOff = r.window.End
+	loaded := make([]StreamRecordWrappers, len(r.tables))
+	var failed bool
+	for i, m := range r.tables {
+		table := &TableReport{TableMapping: m}
+		report.Tables = append(report.Tables, table)
+		recs, err := r.load(ctx, table)
+		if err != nil {
+			r.logger.Error("Error reading table", "source", m.Source, "error", err)
+			table.Error = err.Error()
+			failed = true
+			continue
+		}
+		loaded[i] = recs
+		if len(recs) == 0 {
+			r.logger.Warn("No records in the window, so the table doesn't limit the cut-off", "source", m.Source)
+			continue
+		}
+		// A table's backups only reach as far as its latest record
+		latest := *recs[len(recs)-1].ApproximateCreationDateTime
+		if report.CutOff.IsZero() || latest.Before(report.CutOff) {
+			report.CutOff = latest
+		}
+	}
+	if failed {
+		// Writing the others would leave the set inconsistent
+		for _, table := range report.Tables {
+			if table.Error == "" {
+				table.Error = "Skipped since another table couldn't be read"
+			}
+		}
+		return
+	}
+	r.logger.Info("Restoring every table up to the cut-off", "cutOff", report.CutOff)
+	w := Window{Start: r.window.Start, End: report.CutOff}
+	for i, table := range report.Tables {
+		var upToCutOff StreamRecordWrappers
+		for _, rec := range loaded[i] {
+			if w.Contains(*rec.ApproximateCreationDateTime) {
+				upToCutOff = append(upToCutOff, rec)
+			}
+		}
+		table.Records = len(upToCutOff)
+		err := r.write(ctx, upToCutOff, table)
+		if err == nil {
+			err = table.incomplete()
+		}
+		if err != nil {
+			r.logger.Error("Error restoring table", "source", table.Source, "target", table.Target, "error", err)
+			table.Error = err.Error()
+		}
+	}
+}
+
+// load reads a table's records in the window, sorted oldest first
+func (r *Restorer) load(ctx context.Context, table *TableReport) (StreamRecordWrappers, error) {
+	r.logger.Info("Reading table", "source", table.Source)
+	objs, err := r.source.Objects(ctx, table.Source, r.window)
+	if err != nil {
+		return nil, err
+	}
+	table.Objects = len(objs)
+	table.ObjectsRead = objs
+	r.progress.Listed(len(objs))
+	perObject, skipped, err := r.readObjects(ctx, objs)
+	if err != nil {
+		return nil, err
+	}
+	table.Skipped = skipped
+	var inWindow StreamRecordWrappers
+	for _, recs := range perObject {
+		for _, rec := range recs {
+			if r.window.Contains(*rec.ApproximateCreationDateTime) {
+				inWindow = append(inWindow, rec)
+			}
+		}
+	}
+	sort.Sort(inWindow)
+	table.Records = len(inWindow)
+	r.logger.Info("Read table", "source", table.Source, "objects", table.Objects, "records", table.Records)
+	return inWindow, nil
+}
+
+// readObjects decodes objects with up to concurrency reads at once, stopping at
+// the first error. Objects the source skipped are listed instead of stopping
+func (r *Restorer) readObjects(ctx context.Context, objs []Object) ([]StreamRecordWrappers, []string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]StreamRecordWrappers, len(objs))
+	skipped := make([]bool, len(objs))
+	sem := make(chan struct{}, r.concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := range objs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			recs, err := r.source.Records(ctx, objs[i])
+			var skip *SkippedObjectError
+			if errors.As(err, &skip) {
+				skipped[i] = true
+				return
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("Error: reading %s: %v", objs[i].Key, err)
+					cancel()
+				})
+				return
+			}
+			results[i] = recs
+			r.progress.Read(len(recs))
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	var skippedKeys []string
+	for i, skip := range skipped {
+		if skip {
+			skippedKeys = append(skippedKeys, objs[i].Key)
+		}
+	}
+	return results, skippedKeys, ctx.Err()
+}
+
+// incomplete is an error if objects were skipped, so a partial table doesn't pass for a whole one
+func (t *TableReport) incomplete() error {
+	if len(t.Skipped) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Error: %d objects couldn't be decoded and were dead-lettered, so the table is incomplete", len(t.Skipped))
+}
+
+// write filters, processes and writes sorted records into the table's target
+func (r *Restorer) write(ctx context.Context, recs StreamRecordWrappers, table *TableReport) error {
+	table.Events = map[string]int{}
+	for _, rec := range recs {
+		table.Events[rec.EventName]++
+	}
+	if r.filter != nil {
+		var matched StreamRecordWrappers
+		for _, rec := range recs {
+			if r.filter.Match(rec) {
+				matched = append(matched, rec)
+			}
+		}
+		table.Filtered = len(recs) - len(matched)
+		recs = matched
+	}
+	recs, err := ProcessAll(ctx, Chain(r.processors...), recs)
+	if err != nil {
+		return err
+	}
+	var last *StreamRecordWrapper
+	if len(recs) > 0 {
+		// Taken before writing, since sinks sort and remove duplicates in place
+		last = recs[len(recs)-1]
+	}
+	if r.dryRun {
+		r.logger.Info("Dry run, not writing", "target", table.Target, "records", len(recs))
+		table.Stats = &WriteStats{}
+		table.applied(last)
+		return nil
+	}
+	r.logger.Info("Writing table", "source", table.Source, "target", table.Target, "records", len(recs))
+	table.Stats, err = r.sink.Write(ctx, table.Target, recs)
+	if err == nil && table.Stats != nil && table.Stats.Failed == 0 {
+		table.applied(last)
+	}
+	return err
+}
+
+// applied moves the table's position up to the last record written, if any were
+func (t *TableReport) applied(last *StreamRecordWrapper) {
+	if last == nil {
+		return
+	}
+	t.LastSequenceNumber = aws.StringValue(last.SequenceNumber)
+	if last.ApproximateCreationDateTime != nil {
+		t.LastEventTime = *last.ApproximateCreationDateTime
+	}
+}
@@ -0,0 +1,71 @@
+package restore
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// Rollback puts a table back the way it was at a point in time, using the
+// old images carried by every change made to it since
+func (a *AWS) Rollback(table string, to time.Time) error {
+	a.logger().Info("Listing keys written after", "table", table, "to", to)
+	keys, err := a.ListPartitions(table, Window{Start: to})
+	if err != nil {
+		return err
+	}
+	a.logger().Info("Batch getting all keys", "objects", len(keys))
+	recs, err := a.BatchGet(keys)
+	if err != nil {
+		return err
+	}
+	undo, err := RollbackRecords(recs, to)
+	if err != nil {
+		return err
+	}
+	a.logger().Info("Rolling back items", "table", table, "items", len(undo), "to", to)
+	_, err = a.BatchWrite(table, undo)
+	return err
+}
+
+// RollbackRecords walks the changes made after a point in time in reverse and
+// computes, per key, the record that restores the image it had before them
+func RollbackRecords(recs StreamRecordWrappers, to time.Time) (StreamRecordWrappers, error) {
+	sort.Sort(recs)
+	undo := map[string]*StreamRecordWrapper{}
+	for i := len(recs) - 1; i >= 0; i-- {
+		rec := recs[i]
+		if !rec.ApproximateCreationDateTime.After(to) {
+			break
+		}
+		if rec.EventName != dynamodbstreams.OperationTypeInsert && len(rec.OldImage) == 0 {
+			return nil, errors.New("Error: " + rec.EventName + " record " + aws.StringValue(rec.SequenceNumber) + " has no old image, the stream needs to capture old images to roll back")
+		}
+		// Earlier changes overwrite later ones, leaving the image from before the first change
+		undo[rec.KeyID()] = rec.undo()
+	}
+	var undone StreamRecordWrappers
+	for _, rec := range undo {
+		undone = append(undone, rec)
+	}
+	sort.Sort(undone)
+	return undone, nil
+}
+
+// undo creates the record that reverses this one
+func (s *StreamRecordWrapper) undo() *StreamRecordWrapper {
+	u := *s
+	if s.EventName == dynamodbstreams.OperationTypeInsert {
+		// The item didn't exist before, so it goes away
+		u.EventName = dynamodbstreams.OperationTypeRemove
+		u.OldImage = s.NewImage
+		u.NewImage = nil
+		return &u
+	}
+	u.EventName = dynamodbstreams.OperationTypeModify
+	u.NewImage = s.OldImage
+	return &u
+}
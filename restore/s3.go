@@ -2,18 +2,28 @@ package restore
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
 
 	gaws "github.com/goamz/goamz/aws"
 	"github.com/goamz/goamz/s3"
 )
 
 const (
-	s3Max = 99999
+	s3Max    = 99999
+	s3Scheme = "s3://"
 )
 
-func s3Svc(cfg *AWSConfig) (*s3.S3, error) {
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// sharedS3Svc signs S3 requests with the shared credentials file
+func sharedS3Svc(cfg *AWSConfig) (*s3.S3, error) {
 	auth, err := gaws.SharedAuth()
 	if err != nil {
 		return nil, err
@@ -23,7 +33,11 @@ func s3Svc(cfg *AWSConfig) (*s3.S3, error) {
 
 // Lists bucket contents as a slice of strings
 func (a *AWS) List() ([]string, error) {
-	objs, err := a.Bucket.GetBucketContents()
+	bucket, err := a.bucket(a.Config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := bucket.GetBucketContents()
 	if err != nil {
 		return nil, err
 	}
@@ -40,24 +54,76 @@ func convertKeys(contents *map[string]s3.Key) []string {
 
 // ListWithPrefix from S3 bucket
 func (a *AWS) ListWithPrefix(prefix string) ([]string, error) {
-	bucketContents := map[string]s3.Key{}
+	objs, err := a.ListObjects(prefix)
+	if err != nil {
+		return []string{}, err
+	}
+	var keys []string
+	for _, obj := range objs {
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// ListObjects lists every object under a prefix, sorted by key
+func (a *AWS) ListObjects(prefix string) ([]s3.Key, error) {
+	return a.ListObjectsAfter(prefix, "")
+}
+
+// ListObjectsAfter lists the objects under a prefix whose keys sort after marker
+func (a *AWS) ListObjectsAfter(prefix, marker string) ([]s3.Key, error) {
+	bucket, err := a.bucket(a.Config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return listKeys(bucket, prefix, marker)
+}
+
+// listKeys lists every key in a bucket under prefix after marker, a page at a time
+func listKeys(bucket *s3.Bucket, prefix, marker string) ([]s3.Key, error) {
+	var objs []s3.Key
 	separator := ""
+	for {
+		res, err := bucket.List(prefix, separator, marker, s3Max)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, res.Contents...)
+		if !res.IsTruncated || len(res.Contents) == 0 {
+			break
+		}
+		// S3 only sends NextMarker when listing with a delimiter
+		marker = res.NextMarker
+		if marker == "" {
+			marker = res.Contents[len(res.Contents)-1].Key
+		}
+	}
+	return objs, nil
+}
+
+// ListTables lists the tables that have backups under the configured prefix
+func (a *AWS) ListTables() ([]string, error) {
+	var tables []string
 	marker := ""
 	for {
-		res, err := a.Bucket.List(prefix, separator, marker, s3Max)
+		bucket, err := a.bucket(a.Config.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		res, err := bucket.List(a.Config.Prefix, "/", marker, s3Max)
 		if err != nil {
-			return []string{}, err
+			return nil, err
 		}
-		for _, key := range res.Contents {
-			bucketContents[key.Key] = key
+		for _, p := range res.CommonPrefixes {
+			tables = append(tables, strings.TrimSuffix(strings.TrimPrefix(p, a.Config.Prefix), "/"))
 		}
-		if res.IsTruncated {
-			marker = res.NextMarker
-		} else {
+		if !res.IsTruncated || res.NextMarker == "" {
 			break
 		}
+		marker = res.NextMarker
 	}
-	return convertKeys(&bucketContents), nil
+	sort.Strings(tables)
+	return tables, nil
 }
 
 // BatchGet from S3 bucket
@@ -66,7 +132,7 @@ func (a *AWS) BatchGet(keys []string) (StreamRecordWrappers, error) {
 	for _, key := range keys {
 		rec, err := a.Get(key)
 		if err != nil {
-			fmt.Println(err)
+			a.logger().Error("Error getting object", "key", key, "error", err)
 			return nil, err
 		}
 		recs = append(recs, rec...)
@@ -76,24 +142,109 @@ func (a *AWS) BatchGet(keys []string) (StreamRecordWrappers, error) {
 
 // Get from S3
 func (a *AWS) Get(key string) (StreamRecordWrappers, error) {
-	ioreader, err := a.Bucket.GetReader(key)
+	r, err := a.open(key)
 	if err != nil {
 		return nil, err
 	}
-	reader := bufio.NewReader(ioreader)
+	defer r.Close()
+	return readRecords(r, func(line int, raw []byte, err error) {
+		a.logger().Warn("Error unmarshalling entry", "key", key, "line", line, "error", err)
+		a.Metrics.decodeError(a.tableOfKey(key))
+		a.deadLetter(&DeadLetter{Kind: DeadLetterDecode, Table: a.tableOfKey(key), Object: key, Line: line,
+			Raw: string(bytes.TrimRight(raw, "\r\n")), Error: err.Error(), Attempts: 1})
+	})
+}
+
+// Put writes an object to any bucket the credentials can reach
+func (a *AWS) Put(bucket, key string, data []byte, contentType string) error {
+	b, err := a.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data, contentType, s3.Private, s3.Options{})
+}
+
+// ParseS3URL splits s3://bucket/key into its bucket and key
+func ParseS3URL(url string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(url, s3Scheme) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(url, s3Scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// open reads an object from S3, decompressing it if it was gzipped
+func (a *AWS) open(key string) (io.ReadCloser, error) {
+	bucket, err := a.bucket(a.Config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return openObject(bucket, key, a.Decryption)
+}
+
+// openObject reads an object from any bucket, decrypting it if dec is set and decompressing it if it was gzipped
+func openObject(bucket *s3.Bucket, key string, dec *Decryption) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	if dec == nil {
+		var err error
+		if body, err = bucket.GetReader(key); err != nil {
+			return nil, err
+		}
+	} else {
+		resp, err := bucket.GetResponseWithHeaders(key, dec.headers())
+		if s3err, ok := err.(*s3.Error); ok && s3err.StatusCode == http.StatusBadRequest && len(dec.SSECustomerKey) > 0 {
+			// S3 refuses customer keys for objects that weren't stored with one, such as compacted snapshots
+			resp, err = bucket.GetResponse(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if body, err = dec.decrypt(resp.Header, resp.Body); err != nil {
+			return nil, fmt.Errorf("Error: Unable to decrypt %s: %v", key, err)
+		}
+	}
+	reader := bufio.NewReader(body)
+	magic, err := reader.Peek(2)
+	if err != nil || magic[0] != gzipMagic[0] || magic[1] != gzipMagic[1] {
+		// Not gzipped, or too short to be
+		return readCloser{reader, body}, nil
+	}
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{gz, body}, nil
+}
+
+// readCloser reads through a decoder but closes the underlying object
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readRecords decodes the JSON lines of a backup object, calling bad for every line that can't be decoded
+func readRecords(r io.Reader, bad func(line int, raw []byte, err error)) (StreamRecordWrappers, error) {
+	reader := bufio.NewReader(r)
 	var recs StreamRecordWrappers
-	for {
+	for line := 1; ; line++ {
 		entry, err := reader.ReadBytes('\n')
-		if err != nil {
-			break
+		if len(bytes.TrimSpace(entry)) > 0 {
+			rec := &StreamRecordWrapper{}
+			if uerr := json.Unmarshal(entry, rec); uerr != nil {
+				bad(line, entry, uerr)
+			} else {
+				recs = append(recs, rec)
+			}
+		}
+		if err == io.EOF {
+			return recs, nil
 		}
-		rec := &StreamRecordWrapper{}
-		err = json.Unmarshal(entry, rec)
 		if err != nil {
-			fmt.Println("Error unmarshalling entry: ", err.Error())
-			continue
+			return recs, err
 		}
-		recs = append(recs, rec)
 	}
-	return recs, nil
 }
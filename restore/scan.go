@@ -0,0 +1,216 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	defaultScanSegments = 4
+	// defaultScanCapacityShare is the share of a provisioned table's read capacity a scan uses when not told otherwise
+	defaultScanCapacityShare = 0.5
+)
+
+// ScanOptions configures ScanSnapshot
+type ScanOptions struct {
+	// Segments is how many parts of the table are scanned at once, defaulting to 4
+	Segments int
+	// ReadCapacity caps the read capacity units consumed a second across all segments.
+	// It defaults to half the table's provisioned read capacity, and on-demand tables aren't paced
+	ReadCapacity float64
+	// ConsistentRead scans with strongly consistent reads, at twice the capacity
+	ConsistentRead bool
+}
+
+// ScanInfo records how a snapshot was taken by scanning its table
+type ScanInfo struct {
+	StartTime     time.Time                    `json:"startTime"`
+	EndTime       time.Time                    `json:"endTime"`
+	Segments      int                          `json:"segments"`
+	CapacityUnits float64                      `json:"capacityUnits"`
+	KeySchema     []*dynamodb.KeySchemaElement `json:"keySchema"`
+}
+
+// capacityPacer spreads requests out so the capacity they consume stays under a rate
+type capacityPacer struct {
+	rate float64
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until the capacity already consumed has been paid off
+func (p *capacityPacer) wait(ctx context.Context) error {
+	if p.rate <= 0 {
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	delay := time.Until(p.next)
+	p.mu.Unlock()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// consumed pushes the next request back by the time units of capacity take to earn
+func (p *capacityPacer) consumed(units float64) {
+	if p.rate <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now := time.Now(); p.next.Before(now) {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(units / p.rate * float64(time.Second)))
+}
+
+// ScanSnapshot takes a baseline snapshot of a table with a parallel Scan and
+// writes it to S3 alongside the table's compacted snapshots, so restores and
+// compaction can start from it. Every item becomes an INSERT made when the scan
+// started. Changes made while the scan runs may or may not be in it, so stream
+// backups have to cover the scan for restores to replay them on top
+func (a *AWS) ScanSnapshot(ctx context.Context, table string, opts ScanOptions) (*SnapshotManifest, error) {
+	td, err := a.getTable(table)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Segments <= 0 {
+		opts.Segments = defaultScanSegments
+	}
+	if opts.ReadCapacity <= 0 && td.ProvisionedThroughput != nil {
+		opts.ReadCapacity = float64(aws.Int64Value(td.ProvisionedThroughput.ReadCapacityUnits)) * defaultScanCapacityShare
+	}
+	var keys []string
+	for _, key := range td.KeySchema {
+		keys = append(keys, aws.StringValue(key.AttributeName))
+	}
+	start := time.Now().UTC().Truncate(time.Second)
+	manifest := &SnapshotManifest{
+		Table:  table,
+		CutOff: start,
+		Scan:   &ScanInfo{StartTime: start, Segments: opts.Segments, KeySchema: td.KeySchema},
+	}
+	dir := a.snapshotPrefix(table) + start.Format(snapshotLayout) + "/"
+	a.logger().Info("Scanning table", "table", table, "segments", opts.Segments, "readCapacity", opts.ReadCapacity, "snapshot", dir)
+
+	pacer := &capacityPacer{rate: opts.ReadCapacity}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for segment := 0; segment < opts.Segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			files, items, units, err := a.scanSegment(ctx, table, segment, keys, dir, start, pacer, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			manifest.Files = append(manifest.Files, files...)
+			manifest.Items += items
+			manifest.Scan.CapacityUnits += units
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(segment)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool { return manifest.Files[i].Key < manifest.Files[j].Key })
+	manifest.Scan.EndTime = time.Now().UTC()
+	manifest.CreatedAt = manifest.Scan.EndTime
+	// The manifest goes last, so a snapshot is only ever found once all of its files are written
+	manifest.Key = dir + snapshotManifest
+	if err := a.putManifest(manifest); err != nil {
+		return nil, err
+	}
+	a.logger().Info("Wrote snapshot", "table", table, "manifest", manifest.Key, "items", manifest.Items,
+		"took", manifest.Scan.EndTime.Sub(start), "capacityUnits", manifest.Scan.CapacityUnits)
+	return manifest, nil
+}
+
+// scanSegment scans one segment of a table, writing its items out every snapshotFileItems
+func (a *AWS) scanSegment(ctx context.Context, table string, segment int, keys []string, dir string, at time.Time,
+	pacer *capacityPacer, opts ScanOptions) (files []Object, items int, units float64, err error) {
+	var buf StreamRecordWrappers
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		key := fmt.Sprintf("%sdata/segment-%04d-part-%05d%s", dir, segment, len(files), ".json"+gzipExt)
+		obj, err := a.putRecords(key, buf)
+		if err != nil {
+			return err
+		}
+		files = append(files, obj)
+		a.logger().Debug("Wrote snapshot file", "table", table, "key", key, "items", len(buf))
+		buf = nil
+		return nil
+	}
+	input := &dynamodb.ScanInput{
+		TableName:              aws.String(table),
+		Segment:                aws.Int64(int64(segment)),
+		TotalSegments:          aws.Int64(int64(opts.Segments)),
+		ConsistentRead:         aws.Bool(opts.ConsistentRead),
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	backoff := batchRetryBackoff
+	for {
+		if err := pacer.wait(ctx); err != nil {
+			return files, items, units, err
+		}
+		res, err := a.Dynamo.ScanWithContext(ctx, input)
+		if err != nil {
+			if !isThrottle(err) {
+				return files, items, units, err
+			}
+			a.logger().Warn("Scan throttled, backing off", "table", table, "segment", segment, "backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return files, items, units, ctx.Err()
+			}
+			backoff *= 2
+			if backoff > maxBatchRetryBackoff {
+				backoff = maxBatchRetryBackoff
+			}
+			continue
+		}
+		backoff = batchRetryBackoff
+		consumed := consumedCapacity(res.ConsumedCapacity)
+		units += consumed
+		pacer.consumed(consumed)
+		for _, item := range res.Items {
+			buf = append(buf, insertRecord(item, keys, at, exportSequenceNumber))
+		}
+		items += len(res.Items)
+		if len(buf) >= snapshotFileItems {
+			if err := flush(); err != nil {
+				return files, items, units, err
+			}
+		}
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+	if err := flush(); err != nil {
+		return files, items, units, err
+	}
+	return files, items, units, nil
+}
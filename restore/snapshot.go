@@ -0,0 +1,232 @@
+package restore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotDir      = "_snapshots/"
+	snapshotManifest = "manifest.json"
+	snapshotLayout   = "20060102T150405Z"
+	// snapshotFileItems is how many items each compacted data file holds
+	snapshotFileItems = 100000
+)
+
+// Snapshot is a full copy of a table at a point in time that changes can be replayed on top of
+type Snapshot interface {
+	Source
+	// Time is the point in time the snapshot holds the table at
+	Time() time.Time
+}
+
+// Time is when the export was taken
+func (e *ExportSource) Time() time.Time {
+	return e.Summary.ExportTime
+}
+
+// SnapshotManifest describes a compacted snapshot of a table's stream backups
+type SnapshotManifest struct {
+	Table string `json:"table"`
+	// CutOff is the time of the latest change the snapshot holds
+	CutOff    time.Time `json:"cutOff"`
+	CreatedAt time.Time `json:"createdAt"`
+	Items     int       `json:"items"`
+	// LastSequenceNumber is the sequence number of the latest change compacted
+	LastSequenceNumber string `json:"lastSequenceNumber,omitempty"`
+	// Base is the manifest of the earlier snapshot this one was built on, if any
+	Base string `json:"base,omitempty"`
+	// Objects counts the backup objects compacted on top of the base
+	Objects int      `json:"objects"`
+	Files   []Object `json:"files"`
+	// Scan is set on snapshots taken by scanning the table rather than compacting backups
+	Scan *ScanInfo `json:"scan,omitempty"`
+	// Key is where the manifest itself is stored
+	Key string `json:"-"`
+}
+
+// SnapshotSource reads the items of a compacted snapshot, each as the last change made to it
+type SnapshotSource struct {
+	a        *AWS
+	Manifest *SnapshotManifest
+}
+
+// NewSnapshotSource reads a compacted snapshot
+func (a *AWS) NewSnapshotSource(manifest *SnapshotManifest) *SnapshotSource {
+	return &SnapshotSource{a: a, Manifest: manifest}
+}
+
+// Objects lists the snapshot's data files
+func (s *SnapshotSource) Objects(ctx context.Context, table string, w Window) ([]Object, error) {
+	return s.Manifest.Files, ctx.Err()
+}
+
+// Records decodes one data file
+func (s *SnapshotSource) Records(ctx context.Context, obj Object) (StreamRecordWrappers, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.a.Get(obj.Key)
+}
+
+// Time is the snapshot's cut-off
+func (s *SnapshotSource) Time() time.Time {
+	return s.Manifest.CutOff
+}
+
+func (a *AWS) snapshotPrefix(table string) string {
+	return a.tablePrefix(table) + snapshotDir
+}
+
+// ListSnapshots lists a table's compacted snapshots, oldest first
+func (a *AWS) ListSnapshots(table string) ([]*SnapshotManifest, error) {
+	keys, err := a.ListObjects(a.snapshotPrefix(table))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*SnapshotManifest
+	for _, key := range keys {
+		if !strings.HasSuffix(key.Key, "/"+snapshotManifest) {
+			continue
+		}
+		bucket, err := a.bucket(a.Config.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := bucket.Get(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		manifest := &SnapshotManifest{}
+		if err := json.Unmarshal(raw, manifest); err != nil {
+			return nil, fmt.Errorf("Error: Unable to read snapshot manifest %s: %v", key.Key, err)
+		}
+		manifest.Key = key.Key
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].CutOff.Before(manifests[j].CutOff) })
+	return manifests, nil
+}
+
+// LatestSnapshot finds a table's most recent snapshot cut off no later than before,
+// or its most recent snapshot if before is zero. It returns nil if there isn't one
+func (a *AWS) LatestSnapshot(table string, before time.Time) (*SnapshotManifest, error) {
+	manifests, err := a.ListSnapshots(table)
+	if err != nil {
+		return nil, err
+	}
+	var latest *SnapshotManifest
+	for _, m := range manifests {
+		if before.IsZero() || !m.CutOff.After(before) {
+			latest = m
+		}
+	}
+	return latest, nil
+}
+
+// Compact reduces a table's stream backups up to the cut-off to the last change
+// to each key and writes them back to S3 as a snapshot. It builds on the latest
+// earlier snapshot, so only the changes made since then are read
+func (a *AWS) Compact(ctx context.Context, table string, cutOff time.Time, opts ...Option) (*SnapshotManifest, error) {
+	cutOff = cutOff.UTC().Truncate(time.Second)
+	base, err := a.LatestSnapshot(table, cutOff)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &SnapshotManifest{Table: table, CutOff: cutOff}
+	var source Source = a
+	if base != nil {
+		if base.CutOff.Equal(cutOff) {
+			return nil, errors.New("Error: " + table + " already has a snapshot at " + cutOff.Format(time.RFC3339))
+		}
+		a.logger().Info("Compacting on top of an earlier snapshot", "table", table, "snapshot", base.Key, "cutOff", base.CutOff)
+		manifest.Base = base.Key
+		source = NewIncrementalSource(a.NewSnapshotSource(base), a)
+	}
+	var compacted StreamRecordWrappers
+	capture := SinkFunc(func(ctx context.Context, target string, recs StreamRecordWrappers) (*WriteStats, error) {
+		compacted = recs
+		return &WriteStats{}, nil
+	})
+	opts = append(opts, WithSource(source), WithSink(capture),
+		WithTables(TableMapping{Source: table, Target: table}), WithWindow(Window{End: cutOff}))
+	r, err := NewRestorer(opts...)
+	if err != nil {
+		return nil, err
+	}
+	report, err := r.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Objects = report.Tables[0].Objects
+	if base != nil {
+		manifest.Objects -= len(base.Files)
+	}
+	manifest.LastSequenceNumber = report.Tables[0].LastSequenceNumber
+	compacted.RemoveDupes()
+	var items StreamRecordWrappers
+	for _, rec := range compacted {
+		// Deleted items have nothing left to restore
+		if rec.isInsertOrModifyOperation() {
+			items = append(items, rec)
+		}
+	}
+	manifest.Items = len(items)
+	dir := a.snapshotPrefix(table) + cutOff.Format(snapshotLayout) + "/"
+	for i := 0; i < len(items); i += snapshotFileItems {
+		end := i + snapshotFileItems
+		if end > len(items) {
+			end = len(items)
+		}
+		key := fmt.Sprintf("%sdata/part-%05d%s", dir, i/snapshotFileItems, ".json"+gzipExt)
+		obj, err := a.putRecords(key, items[i:end])
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, obj)
+	}
+	// The manifest goes last, so a snapshot is only ever found once all of its files are written
+	manifest.CreatedAt = time.Now().UTC()
+	manifest.Key = dir + snapshotManifest
+	if err := a.putManifest(manifest); err != nil {
+		return nil, err
+	}
+	a.logger().Info("Wrote snapshot", "table", table, "manifest", manifest.Key, "items", manifest.Items,
+		"lastSequenceNumber", manifest.LastSequenceNumber)
+	return manifest, nil
+}
+
+// putManifest writes a snapshot's manifest to its key
+func (a *AWS) putManifest(manifest *SnapshotManifest) error {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.Put(a.Config.Bucket, manifest.Key, raw, "application/json")
+}
+
+// putRecords writes records as a gzipped backup object
+func (a *AWS) putRecords(key string, recs StreamRecordWrappers) (Object, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return Object{}, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return Object{}, err
+	}
+	if err := a.Put(a.Config.Bucket, key, buf.Bytes(), "application/gzip"); err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, Size: int64(buf.Len())}, nil
+}
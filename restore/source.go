@@ -0,0 +1,92 @@
+package restore
+
+import (
+	"context"
+
+	"github.com/goamz/goamz/s3"
+)
+
+// Object is one backup object a Source reads records from
+type Object struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// keyObject is the Object for a listed S3 key
+func keyObject(key s3.Key) Object {
+	return Object{Key: key.Key, Size: key.Size, ETag: key.ETag}
+}
+
+// SkippedObjectError is returned by a Source for an object it couldn't read any
+// records from but has dead-lettered. The restore carries on without it, and
+// the table is reported as incomplete
+type SkippedObjectError struct {
+	Key string
+	Err error
+}
+
+func (e *SkippedObjectError) Error() string {
+	return "skipped " + e.Key + ": " + e.Err.Error()
+}
+
+// Source reads backed up records for a table
+type Source interface {
+	// Objects lists the backup objects that can hold records of a table in the window, oldest first
+	Objects(ctx context.Context, table string, w Window) ([]Object, error)
+	// Records decodes every record in an object
+	Records(ctx context.Context, obj Object) (StreamRecordWrappers, error)
+}
+
+// Sink writes records into a table
+type Sink interface {
+	Write(ctx context.Context, table string, recs StreamRecordWrappers) (*WriteStats, error)
+}
+
+// SinkFunc lets a plain function be used as a Sink
+type SinkFunc func(ctx context.Context, table string, recs StreamRecordWrappers) (*WriteStats, error)
+
+// Write calls f
+func (f SinkFunc) Write(ctx context.Context, table string, recs StreamRecordWrappers) (*WriteStats, error) {
+	return f(ctx, table, recs)
+}
+
+// Objects lists a table's backup objects in S3
+func (a *AWS) Objects(ctx context.Context, table string, w Window) ([]Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys, err := a.ListPartitionObjects(table, w)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]Object, len(keys))
+	for i, key := range keys {
+		objs[i] = keyObject(key)
+	}
+	return objs, nil
+}
+
+// Records gets and decodes an object from S3
+func (a *AWS) Records(ctx context.Context, obj Object) (StreamRecordWrappers, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	recs, err := a.Get(obj.Key)
+	if err == nil {
+		a.Metrics.objectFetched(a.tableOfKey(obj.Key), obj.Size)
+	}
+	return recs, err
+}
+
+// Write batch writes records into a table
+func (a *AWS) Write(ctx context.Context, table string, recs StreamRecordWrappers) (*WriteStats, error) {
+	return a.BatchWriteWithContext(ctx, table, recs)
+}
+
+// ConditionalSink writes into live tables item by item, guarded by the policy
+func (a *AWS) ConditionalSink(policy *WritePolicy) Sink {
+	return SinkFunc(func(ctx context.Context, table string, recs StreamRecordWrappers) (*WriteStats, error) {
+		return a.ConditionalWriteWithContext(ctx, table, recs, policy)
+	})
+}
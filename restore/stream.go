@@ -0,0 +1,479 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/goamz/goamz/s3"
+)
+
+const (
+	checkpointDir = "_checkpoints/"
+	// getRecordsLimit is the most records GetRecords returns at once
+	getRecordsLimit = 1000
+	// closedShardPace spaces out reads of a closed shard that come back empty, staying under GetRecords' limit of 5 a second
+	closedShardPace     = 250 * time.Millisecond
+	defaultPollInterval = 10 * time.Second
+)
+
+// ShardCheckpoint is how far a shard has been read
+type ShardCheckpoint struct {
+	SequenceNumber string `json:"sequenceNumber,omitempty"`
+	// Done shards are closed and fully read
+	Done bool `json:"done,omitempty"`
+}
+
+// StreamCheckpoint is how far each shard of a table's stream has been read
+type StreamCheckpoint struct {
+	StreamArn string                      `json:"streamArn"`
+	Shards    map[string]*ShardCheckpoint `json:"shards"`
+	UpdatedAt time.Time                   `json:"updatedAt"`
+}
+
+// Checkpoints stores stream checkpoints by name
+type Checkpoints interface {
+	// Load reads a checkpoint, which is empty if it hasn't been saved yet
+	Load(name string) (*StreamCheckpoint, error)
+	Save(name string, cp *StreamCheckpoint) error
+}
+
+// FileCheckpoints keeps checkpoints as JSON files under a directory
+type FileCheckpoints struct {
+	Dir string
+}
+
+func (c *FileCheckpoints) path(name string) string {
+	return filepath.Join(c.Dir, filepath.FromSlash(name)+".json")
+}
+
+// Load reads a checkpoint file
+func (c *FileCheckpoints) Load(name string) (*StreamCheckpoint, error) {
+	raw, err := os.ReadFile(c.path(name))
+	if os.IsNotExist(err) {
+		return newStreamCheckpoint(""), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeCheckpoint(raw)
+}
+
+// Save writes a checkpoint file, replacing it in one step so a crash never leaves it half written
+func (c *FileCheckpoints) Save(name string, cp *StreamCheckpoint) error {
+	raw, err := encodeCheckpoint(cp)
+	if err != nil {
+		return err
+	}
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// S3Checkpoints keeps checkpoints as JSON objects under a prefix
+type S3Checkpoints struct {
+	a      *AWS
+	bucket string
+	prefix string
+}
+
+// NewS3Checkpoints keeps checkpoints under s3://bucket/prefix
+func (a *AWS) NewS3Checkpoints(bucket, prefix string) *S3Checkpoints {
+	return &S3Checkpoints{a: a, bucket: bucket, prefix: prefix}
+}
+
+// Load reads a checkpoint object
+func (c *S3Checkpoints) Load(name string) (*StreamCheckpoint, error) {
+	bucket, err := c.a.bucket(c.bucket)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := bucket.Get(c.prefix + name + ".json")
+	if err != nil {
+		if s3err, ok := err.(*s3.Error); ok && s3err.StatusCode == http.StatusNotFound {
+			return newStreamCheckpoint(""), nil
+		}
+		return nil, err
+	}
+	return decodeCheckpoint(raw)
+}
+
+// Save writes a checkpoint object
+func (c *S3Checkpoints) Save(name string, cp *StreamCheckpoint) error {
+	raw, err := encodeCheckpoint(cp)
+	if err != nil {
+		return err
+	}
+	return c.a.Put(c.bucket, c.prefix+name+".json", raw, "application/json")
+}
+
+// OpenCheckpoints keeps checkpoints in a local directory or under s3://bucket/prefix
+func (a *AWS) OpenCheckpoints(location string) Checkpoints {
+	if bucket, prefix, ok := ParseS3URL(location); ok {
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return a.NewS3Checkpoints(bucket, prefix)
+	}
+	return &FileCheckpoints{Dir: location}
+}
+
+func newStreamCheckpoint(streamArn string) *StreamCheckpoint {
+	return &StreamCheckpoint{StreamArn: streamArn, Shards: map[string]*ShardCheckpoint{}}
+}
+
+func decodeCheckpoint(raw []byte) (*StreamCheckpoint, error) {
+	cp := &StreamCheckpoint{}
+	if err := json.Unmarshal(raw, cp); err != nil {
+		return nil, fmt.Errorf("Error: Unable to read stream checkpoint: %v", err)
+	}
+	if cp.Shards == nil {
+		cp.Shards = map[string]*ShardCheckpoint{}
+	}
+	return cp, nil
+}
+
+func encodeCheckpoint(cp *StreamCheckpoint) ([]byte, error) {
+	cp.UpdatedAt = time.Now().UTC()
+	return json.MarshalIndent(cp, "", "  ")
+}
+
+// streamTail reads a table's stream shard by shard, parents before children,
+// handing each shard's records over in order and checkpointing after each batch
+type streamTail struct {
+	table       string
+	checkpoints Checkpoints
+	name        string
+	// batch is how many records are read from a shard before they're handed over
+	batch int
+	// maxAge is how long a caught up shard's records are held for more to arrive
+	// while following, so a quiet table isn't handed over a page at a time. Zero
+	// hands them over as soon as the shard is caught up
+	maxAge time.Duration
+	handle func(ctx context.Context, shardID string, recs StreamRecordWrappers) error
+
+	cp *StreamCheckpoint
+	// held is what's been read from each shard but not yet handed over
+	held map[string]*heldRecords
+}
+
+// heldRecords are a shard's records read past its checkpoint
+type heldRecords struct {
+	recs  StreamRecordWrappers
+	since time.Time
+}
+
+// tailStream reads a stream from its checkpoint until it's caught up or, when
+// following, until ctx is done
+func (a *AWS) tailStream(ctx context.Context, t *streamTail, follow bool, pollInterval time.Duration) error {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	streamArn, err := a.streamArn(t.table)
+	if err != nil {
+		return err
+	}
+	if t.cp, err = t.checkpoints.Load(t.name); err != nil {
+		return err
+	}
+	if t.cp.StreamArn != streamArn {
+		if t.cp.StreamArn != "" {
+			a.logger().Warn("Table has a new stream, reading it from the start", "table", t.table, "old", t.cp.StreamArn, "new", streamArn)
+		}
+		t.cp = newStreamCheckpoint(streamArn)
+	}
+	t.held = map[string]*heldRecords{}
+	err = a.pollStream(ctx, t, streamArn, follow, pollInterval)
+	if ctx.Err() != nil {
+		// Stopping doesn't leave held records unwritten
+		if ferr := a.flushHeld(ctx, t); ferr != nil {
+			return ferr
+		}
+	}
+	return err
+}
+
+// pollStream reads every ready shard until none of them have anything new or,
+// when following, until ctx is done
+func (a *AWS) pollStream(ctx context.Context, t *streamTail, streamArn string, follow bool, pollInterval time.Duration) error {
+	for {
+		shards, err := a.describeShards(ctx, streamArn)
+		if err != nil {
+			return err
+		}
+		progressed := false
+		for _, shard := range readyShards(shards, t.cp) {
+			read, err := a.readShard(ctx, t, shard, follow)
+			if err != nil {
+				return err
+			}
+			progressed = progressed || read
+		}
+		pruneCheckpoint(t.cp, shards)
+		if !progressed {
+			if !follow {
+				return nil
+			}
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// flushHeld hands over every shard's held records
+func (a *AWS) flushHeld(ctx context.Context, t *streamTail) error {
+	for id, held := range t.held {
+		if err := t.flush(ctx, id, held); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush hands a shard's held records over and checkpoints past them
+func (t *streamTail) flush(ctx context.Context, shardID string, held *heldRecords) error {
+	if len(held.recs) == 0 {
+		return nil
+	}
+	if err := t.handle(ctx, shardID, held.recs); err != nil {
+		return err
+	}
+	t.cp.Shards[shardID].SequenceNumber = aws.StringValue(held.recs[len(held.recs)-1].SequenceNumber)
+	held.recs = nil
+	return t.checkpoints.Save(t.name, t.cp)
+}
+
+// streamArn finds a table's stream, warning if it won't carry old images
+func (a *AWS) streamArn(table string) (string, error) {
+	td, err := a.getTable(table)
+	if err != nil {
+		return "", err
+	}
+	streamArn := aws.StringValue(td.LatestStreamArn)
+	if streamArn == "" {
+		return "", errors.New("Error: " + table + " doesn't have a stream enabled")
+	}
+	if td.StreamSpecification != nil && aws.StringValue(td.StreamSpecification.StreamViewType) != dynamodbstreams.StreamViewTypeNewAndOldImages {
+		a.logger().Warn("Stream doesn't capture both images, so rollbacks and audits will be limited", "table", table,
+			"viewType", aws.StringValue(td.StreamSpecification.StreamViewType))
+	}
+	return streamArn, nil
+}
+
+// describeShards lists every shard of a stream
+func (a *AWS) describeShards(ctx context.Context, streamArn string) ([]*dynamodbstreams.Shard, error) {
+	var shards []*dynamodbstreams.Shard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(streamArn)}
+	for {
+		res, err := a.Streams.DescribeStreamWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, res.StreamDescription.Shards...)
+		if res.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = res.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// readyShards lists the shards that aren't done and whose parents are, oldest first
+func readyShards(shards []*dynamodbstreams.Shard, cp *StreamCheckpoint) []*dynamodbstreams.Shard {
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.StringValue(shard.ShardId)] = true
+	}
+	var ready []*dynamodbstreams.Shard
+	for _, shard := range shards {
+		id := aws.StringValue(shard.ShardId)
+		if sc, ok := cp.Shards[id]; ok && sc.Done {
+			continue
+		}
+		parent := aws.StringValue(shard.ParentShardId)
+		// A parent that's no longer listed has aged out of the stream, so there's nothing left to wait for
+		if parent != "" && listed[parent] && (cp.Shards[parent] == nil || !cp.Shards[parent].Done) {
+			continue
+		}
+		ready = append(ready, shard)
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return compareSequenceNumbers(startingSequenceNumber(ready[i]), startingSequenceNumber(ready[j])) < 0
+	})
+	return ready
+}
+
+func startingSequenceNumber(shard *dynamodbstreams.Shard) *string {
+	if shard.SequenceNumberRange == nil {
+		return nil
+	}
+	return shard.SequenceNumberRange.StartingSequenceNumber
+}
+
+// pruneCheckpoint forgets finished shards that have aged out of the stream
+func pruneCheckpoint(cp *StreamCheckpoint, shards []*dynamodbstreams.Shard) {
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.StringValue(shard.ShardId)] = true
+	}
+	for id, sc := range cp.Shards {
+		if sc.Done && !listed[id] {
+			delete(cp.Shards, id)
+		}
+	}
+}
+
+// readShard reads a shard from where it was last read until it's closed or
+// caught up, reporting whether it read anything or finished the shard. When
+// following, a caught up shard's records are held until the batch fills or
+// they've been held for maxAge
+func (a *AWS) readShard(ctx context.Context, t *streamTail, shard *dynamodbstreams.Shard, follow bool) (bool, error) {
+	id := aws.StringValue(shard.ShardId)
+	sc, ok := t.cp.Shards[id]
+	if !ok {
+		sc = &ShardCheckpoint{}
+		t.cp.Shards[id] = sc
+	}
+	held, ok := t.held[id]
+	if !ok {
+		held = &heldRecords{}
+		t.held[id] = held
+	}
+	// Held records have been read past the checkpoint, so reading carries on after them
+	position := func() *ShardCheckpoint {
+		if len(held.recs) == 0 {
+			return sc
+		}
+		return &ShardCheckpoint{SequenceNumber: aws.StringValue(held.recs[len(held.recs)-1].SequenceNumber)}
+	}
+	flush := func() error {
+		return t.flush(ctx, id, held)
+	}
+	closed := shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil
+	iterator, err := a.shardIterator(ctx, t.cp.StreamArn, id, position())
+	if err != nil {
+		return false, err
+	}
+	read := false
+	for iterator != nil {
+		res, err := a.Streams.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+			Limit:         aws.Int64(getRecordsLimit),
+		})
+		if err != nil {
+			if !isStreamErr(err, dynamodbstreams.ErrCodeExpiredIteratorException) {
+				return read, err
+			}
+			// Iterators last 15 minutes, so start again from what's been read
+			if iterator, err = a.shardIterator(ctx, t.cp.StreamArn, id, position()); err != nil {
+				return read, err
+			}
+			continue
+		}
+		if len(held.recs) == 0 && len(res.Records) > 0 {
+			held.since = time.Now()
+		}
+		for _, r := range res.Records {
+			held.recs = append(held.recs, streamRecordWrapper(r))
+		}
+		read = read || len(res.Records) > 0
+		if len(held.recs) >= t.batch {
+			if err := flush(); err != nil {
+				return read, err
+			}
+		}
+		iterator = res.NextShardIterator
+		if len(res.Records) == 0 && iterator != nil {
+			if !closed {
+				// Caught up with an open shard
+				break
+			}
+			// Closed shards can read empty for a while before they run out
+			select {
+			case <-time.After(closedShardPace):
+			case <-ctx.Done():
+				if err := flush(); err != nil {
+					return read, err
+				}
+				return read, ctx.Err()
+			}
+		}
+	}
+	if iterator != nil && follow && time.Since(held.since) < t.maxAge {
+		// Hold a caught up shard's records a while longer for more to arrive
+		return read, nil
+	}
+	if err := flush(); err != nil {
+		return read, err
+	}
+	if iterator == nil {
+		a.logger().Debug("Finished shard", "table", t.table, "shard", id)
+		sc.Done = true
+		delete(t.held, id)
+		return true, t.checkpoints.Save(t.name, t.cp)
+	}
+	return read, nil
+}
+
+// shardIterator starts reading a shard after its checkpoint, or from its oldest record
+func (a *AWS) shardIterator(ctx context.Context, streamArn, shardID string, sc *ShardCheckpoint) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(streamArn),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	if sc.SequenceNumber != "" {
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(sc.SequenceNumber)
+	}
+	res, err := a.Streams.GetShardIteratorWithContext(ctx, input)
+	if err != nil && sc.SequenceNumber != "" && isStreamErr(err, dynamodbstreams.ErrCodeTrimmedDataAccessException) {
+		// Records past the checkpoint aged out of the stream before they were read
+		a.logger().Error("Stream trimmed past the checkpoint, some changes were lost", "shard", shardID, "checkpoint", sc.SequenceNumber)
+		sc.SequenceNumber = ""
+		return a.shardIterator(ctx, streamArn, shardID, sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.ShardIterator, nil
+}
+
+// streamRecordWrapper converts a record read from a stream into the backup format
+func streamRecordWrapper(r *dynamodbstreams.Record) *StreamRecordWrapper {
+	sr := r.Dynamodb
+	return &StreamRecordWrapper{
+		ApproximateCreationDateTime: sr.ApproximateCreationDateTime,
+		Keys:                        sr.Keys,
+		NewImage:                    sr.NewImage,
+		OldImage:                    sr.OldImage,
+		SequenceNumber:              sr.SequenceNumber,
+		SizeBytes:                   sr.SizeBytes,
+		StreamViewType:              sr.StreamViewType,
+		EventName:                   aws.StringValue(r.EventName),
+	}
+}
+
+func isStreamErr(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+	return false
+}
@@ -2,10 +2,11 @@ package restore
 
 import (
 	"encoding/json"
-	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 )
@@ -35,27 +36,43 @@ func (s StreamRecordWrappers) Len() int {
 }
 
 func (s StreamRecordWrappers) Less(i, j int) bool {
-	return s[i].ApproximateCreationDateTime.Before(*s[j].ApproximateCreationDateTime)
+	ti, tj := s[i].ApproximateCreationDateTime, s[j].ApproximateCreationDateTime
+	if !ti.Equal(*tj) {
+		return ti.Before(*tj)
+	}
+	// Creation times only have second precision, so fall back to the stream's ordering
+	return compareSequenceNumbers(s[i].SequenceNumber, s[j].SequenceNumber) < 0
 }
 
 func (s StreamRecordWrappers) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+// RemoveDupes keeps only the last record for each key, so records need to be sorted first
 func (s *StreamRecordWrappers) RemoveDupes() {
-	c := *s
-	dupeKey := map[string]int{}
-	for i, rec := range c {
-		// Check for a duplicate key
-		attr := *rec.GetImage()[rec.KeyName()].S
-		if j, ok := dupeKey[attr]; ok {
-			c.Remove(j)
-			dupeKey[attr] = i - 1
-		} else {
-			dupeKey[attr] = i
+	last := map[string]int{}
+	for i, rec := range *s {
+		last[rec.KeyID()] = i
+	}
+	var deduped StreamRecordWrappers
+	for i, rec := range *s {
+		if last[rec.KeyID()] == i {
+			deduped = append(deduped, rec)
 		}
 	}
-	*s = c
+	*s = deduped
+}
+
+// compareSequenceNumbers orders stream sequence numbers, which are decimal strings of varying length
+func compareSequenceNumbers(a, b *string) int {
+	sa, sb := aws.StringValue(a), aws.StringValue(b)
+	if len(sa) != len(sb) {
+		if len(sa) < len(sb) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(sa, sb)
 }
 
 type WriteRequests []*dynamodb.WriteRequest
@@ -75,7 +92,6 @@ func (s *StreamRecordWrapper) UnmarshalJSON(b []byte) error {
 		Alias: (*Alias)(s),
 	}
 	if err := json.Unmarshal(b, &aux); err != nil {
-		fmt.Println("Error in the streamrecordwrapper unmarshal: ", err.Error())
 		return err
 	}
 	converted := time.Unix(aux.ApproximateCreationDateTime, 0)
@@ -83,6 +99,38 @@ func (s *StreamRecordWrapper) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// MarshalJSON writes the same backup line format UnmarshalJSON reads
+func (s *StreamRecordWrapper) MarshalJSON() ([]byte, error) {
+	type Alias StreamRecordWrapper
+	aux := &struct {
+		ApproximateCreationDateTime int64           `json:"ApproximateCreationDateTime"`
+		Keys                        json.RawMessage `json:"Keys"`
+		NewImage                    json.RawMessage `json:"NewImage,omitempty"`
+		OldImage                    json.RawMessage `json:"OldImage,omitempty"`
+		*Alias
+	}{
+		Alias: (*Alias)(s),
+	}
+	if s.ApproximateCreationDateTime != nil {
+		aux.ApproximateCreationDateTime = s.ApproximateCreationDateTime.Unix()
+	}
+	var err error
+	if aux.Keys, err = MarshalAttributeValues(s.Keys); err != nil {
+		return nil, err
+	}
+	if s.NewImage != nil {
+		if aux.NewImage, err = MarshalAttributeValues(s.NewImage); err != nil {
+			return nil, err
+		}
+	}
+	if s.OldImage != nil {
+		if aux.OldImage, err = MarshalAttributeValues(s.OldImage); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(aux)
+}
+
 func (s *StreamRecordWrapper) CreateWriteRequest() *dynamodb.WriteRequest {
 	// Insert & modify are both put requests
 	if s.isInsertOrModifyOperation() {
@@ -94,7 +142,7 @@ func (s *StreamRecordWrapper) CreateWriteRequest() *dynamodb.WriteRequest {
 	}
 	return &dynamodb.WriteRequest{
 		DeleteRequest: &dynamodb.DeleteRequest{
-			Key: s.OldImage,
+			Key: s.Keys,
 		},
 	}
 }
@@ -112,6 +160,13 @@ func (s *StreamRecordWrapper) KeyName() (keyName string) {
 	return keyName
 }
 
+// KeyID identifies the item a record belongs to across all of its key attributes
+func (s *StreamRecordWrapper) KeyID() string {
+	// Maps marshal with sorted keys, so this is stable
+	id, _ := MarshalAttributeValues(s.Keys)
+	return string(id)
+}
+
 func (s *StreamRecordWrapper) IsDupe(os *StreamRecordWrapper) bool {
 	own := s.GetImage()
 	other := os.GetImage()
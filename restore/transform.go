@@ -0,0 +1,203 @@
+package restore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// TransformType names a change made to an attribute during restore
+type TransformType string
+
+const (
+	// TransformRename moves an attribute to a new name
+	TransformRename TransformType = "rename"
+	// TransformDrop removes an attribute
+	TransformDrop TransformType = "drop"
+	// TransformSet sets an attribute to a constant
+	TransformSet TransformType = "set"
+	// TransformReplace rewrites string values with a regular expression
+	TransformReplace TransformType = "replace"
+	// TransformHash replaces string values with their salted SHA-256
+	TransformHash TransformType = "hash"
+	// TransformTokenize replaces string values with a short keyed token, stable across records
+	TransformTokenize TransformType = "tokenize"
+)
+
+const tokenPrefix = "tok_"
+
+// Transform is one declarative change applied to every restored record. Rename,
+// replace, hash and tokenize apply to keys as well as images so they stay in step
+type Transform struct {
+	Type      TransformType `mapstructure:"type" json:"type"`
+	Attribute string        `mapstructure:"attribute" json:"attribute"`
+	// To is the new name for rename
+	To string `mapstructure:"to" json:"to,omitempty"`
+	// Value and ValueType (S, N or BOOL, defaulting to S) are the constant for set
+	Value     string `mapstructure:"value" json:"value,omitempty"`
+	ValueType string `mapstructure:"valueType" json:"valueType,omitempty"`
+	// Pattern and Replacement are the regular expression and its replacement for replace
+	Pattern     string `mapstructure:"pattern" json:"pattern,omitempty"`
+	Replacement string `mapstructure:"replacement" json:"replacement,omitempty"`
+	// Salt keys hash and tokenize
+	Salt string `mapstructure:"salt" json:"-"`
+
+	pattern *regexp.Regexp
+}
+
+// Transforms run in order
+type Transforms []*Transform
+
+// Validate checks each transform has what it needs and compiles its pattern
+func (ts Transforms) Validate() error {
+	for i, t := range ts {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("Error: transform %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (t *Transform) validate() error {
+	if t.Attribute == "" {
+		return errors.New("missing attribute")
+	}
+	switch t.Type {
+	case TransformRename:
+		if t.To == "" {
+			return errors.New("rename needs a new name in to")
+		}
+	case TransformDrop, TransformHash, TransformTokenize:
+	case TransformSet:
+		switch t.ValueType {
+		case "", string(DynamoStringType):
+		case string(DynamoNumberType):
+			// DynamoDB only takes decimal numbers, so Go's NaN, Inf and hex floats are turned away too
+			n, err := strconv.ParseFloat(t.Value, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || strings.ContainsAny(t.Value, "xX") {
+				return fmt.Errorf("set value %q isn't a number", t.Value)
+			}
+		case string(DynamoBoolType):
+			if _, err := strconv.ParseBool(t.Value); err != nil {
+				return fmt.Errorf("set value %q isn't true or false", t.Value)
+			}
+		default:
+			return errors.New("set only supports S, N and BOOL values")
+		}
+	case TransformReplace:
+		pattern, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return err
+		}
+		t.pattern = pattern
+	default:
+		return errors.New("unknown type " + string(t.Type))
+	}
+	return nil
+}
+
+// String describes the transform for dry runs
+func (t *Transform) String() string {
+	switch t.Type {
+	case TransformRename:
+		return fmt.Sprintf("rename %s to %s", t.Attribute, t.To)
+	case TransformSet:
+		valueType := t.ValueType
+		if valueType == "" {
+			valueType = string(DynamoStringType)
+		}
+		return fmt.Sprintf("set %s to %s %q", t.Attribute, valueType, t.Value)
+	case TransformReplace:
+		return fmt.Sprintf("replace /%s/ with %q in %s", t.Pattern, t.Replacement, t.Attribute)
+	}
+	return fmt.Sprintf("%s %s", t.Type, t.Attribute)
+}
+
+// Apply runs every transform over a record's keys and images. The transforms have to have been validated
+func (ts Transforms) Apply(rec *StreamRecordWrapper) {
+	for _, t := range ts {
+		if t.appliesToKeys() {
+			t.apply(rec.Keys)
+		}
+		t.apply(rec.NewImage)
+		t.apply(rec.OldImage)
+	}
+}
+
+// appliesToKeys leaves out transforms that would remove or replace a key outright
+func (t *Transform) appliesToKeys() bool {
+	return t.Type != TransformDrop && t.Type != TransformSet
+}
+
+func (t *Transform) apply(image map[string]*dynamodb.AttributeValue) {
+	if image == nil {
+		return
+	}
+	av, ok := image[t.Attribute]
+	if !ok && t.Type != TransformSet {
+		return
+	}
+	switch t.Type {
+	case TransformRename:
+		delete(image, t.Attribute)
+		image[t.To] = av
+	case TransformDrop:
+		delete(image, t.Attribute)
+	case TransformSet:
+		image[t.Attribute] = t.constant()
+	default:
+		image[t.Attribute] = mapStrings(av, t.rewrite)
+	}
+}
+
+func (t *Transform) constant() *dynamodb.AttributeValue {
+	switch DynamoType(t.ValueType) {
+	case DynamoNumberType:
+		return &dynamodb.AttributeValue{N: aws.String(t.Value)}
+	case DynamoBoolType:
+		// Validated already, so the value parses
+		b, _ := strconv.ParseBool(t.Value)
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(b)}
+	}
+	return &dynamodb.AttributeValue{S: aws.String(t.Value)}
+}
+
+// rewrite changes a single string value
+func (t *Transform) rewrite(s string) string {
+	switch t.Type {
+	case TransformReplace:
+		return t.pattern.ReplaceAllString(s, t.Replacement)
+	case TransformHash:
+		sum := sha256.Sum256([]byte(t.Salt + s))
+		return hex.EncodeToString(sum[:])
+	case TransformTokenize:
+		mac := hmac.New(sha256.New, []byte(t.Salt))
+		mac.Write([]byte(s))
+		return tokenPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return s
+}
+
+// mapStrings copies an attribute value with fn applied to its strings, leaving other types alone
+func mapStrings(av *dynamodb.AttributeValue, fn func(string) string) *dynamodb.AttributeValue {
+	switch {
+	case av.S != nil:
+		return &dynamodb.AttributeValue{S: aws.String(fn(*av.S))}
+	case av.SS != nil:
+		ss := make([]*string, len(av.SS))
+		for i, s := range av.SS {
+			ss[i] = aws.String(fn(aws.StringValue(s)))
+		}
+		return &dynamodb.AttributeValue{SS: ss}
+	}
+	return av
+}